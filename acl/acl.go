@@ -0,0 +1,76 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// List - networks that are allowed and denied. an address is permitted when it is in an allowed
+// network (or there are no allowed networks) and it is not in a denied network.
+type List struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// New - creates a list from CIDR strings, a bare address is treated as a network containing only that address
+func New(allow []string, deny []string) (*List, error) {
+	allowNets, err := ParseNetworks(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := ParseNetworks(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &List{allow: allowNets, deny: denyNets}, nil
+}
+
+// ParseNetworks - parses each CIDR string (or bare address) into a network
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("\"%s\" is not a valid address or network", cidr)
+			}
+			if ip.To4() != nil {
+				cidr = cidr + "/32"
+			} else {
+				cidr = cidr + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("\"%s\" is not a valid address or network", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Permits - returns true if the address is allowed by the list, a nil list permits everything
+func (list *List) Permits(ip net.IP) bool {
+	if list == nil {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range list.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(list.allow) < 1 {
+		return true
+	}
+	for _, network := range list.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
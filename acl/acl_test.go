@@ -0,0 +1,64 @@
+package acl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	data := []struct {
+		input []string
+		valid bool
+	}{
+		{[]string{}, true},
+		{[]string{"10.0.0.0/8", "fc00::/7"}, true},
+		{[]string{"127.0.0.1", "::1"}, true},
+		{[]string{" 192.168.0.0/16 "}, true},
+		{[]string{"10.0.0.0/33"}, false},
+		{[]string{"gyip.io"}, false},
+		{[]string{"10.0.0.0/8", ""}, false},
+	}
+
+	for _, item := range data {
+		_, err := ParseNetworks(item.input)
+		if item.valid != (err == nil) {
+			t.Errorf("The networks %v were not parsed as expected (error: %v, expected valid: %t)", item.input, err, item.valid)
+		}
+	}
+}
+
+func TestPermits(t *testing.T) {
+	data := []struct {
+		allow     []string
+		deny      []string
+		ip        string
+		permitted bool
+	}{
+		{nil, nil, "45.13.12.90", true},
+		{nil, []string{"169.254.0.0/16"}, "169.254.169.254", false},
+		{nil, []string{"169.254.0.0/16"}, "::ffff:169.254.169.254", false},
+		{nil, []string{"169.254.0.0/16"}, "10.0.0.1", true},
+		{[]string{"10.0.0.0/8", "127.0.0.1"}, nil, "10.1.2.3", true},
+		{[]string{"10.0.0.0/8", "127.0.0.1"}, nil, "127.0.0.1", true},
+		{[]string{"10.0.0.0/8", "127.0.0.1"}, nil, "127.0.0.2", false},
+		{[]string{"10.0.0.0/8", "127.0.0.1"}, nil, "::1", false},
+		{[]string{"fc00::/7"}, []string{"fd00:ec2::254"}, "fd00:ec2::254", false},
+		{[]string{"fc00::/7"}, []string{"fd00:ec2::254"}, "fd00:ec2::253", true},
+	}
+
+	for _, item := range data {
+		list, err := New(item.allow, item.deny)
+		if err != nil {
+			t.Errorf("Could not create list (allow: %v, deny: %v): %s", item.allow, item.deny, err)
+			continue
+		}
+		if permitted := list.Permits(net.ParseIP(item.ip)); permitted != item.permitted {
+			t.Errorf("The address %s was not permitted as expected (allow: %v, deny: %v, was: %t, expected %t)", item.ip, item.allow, item.deny, permitted, item.permitted)
+		}
+	}
+
+	var nilList *List
+	if !nilList.Permits(net.ParseIP("10.0.0.1")) {
+		t.Errorf("A nil list should permit every address")
+	}
+}
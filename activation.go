@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/chrisruffalo/gyip/logging"
+)
+
+// the first file descriptor passed by systemd (after stdin, stdout, and stderr)
+const listenFDsStart = 3
+
+// set for a process that was started to take over the sockets of the process with this pid
+const parentPIDVariable = "GYIP_PARENT_PID"
+
+// the sockets passed in by systemd socket activation (LISTEN_FDS) or by a parent process that is handing
+// its sockets over. systemd sets LISTEN_PID to the pid of the process the sockets are for, a parent process
+// doesn't know the pid of the new process ahead of time and leaves it out.
+func inheritedSockets() ([]*socket, error) {
+	count := os.Getenv("LISTEN_FDS")
+	pid := os.Getenv("LISTEN_PID")
+	names := os.Getenv("LISTEN_FDNAMES")
+	if count == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil, nil
+	}
+
+	// the sockets aren't meant for any processes that are started later
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	fds, err := strconv.Atoi(count)
+	if err != nil || fds < 1 {
+		return nil, fmt.Errorf("LISTEN_FDS has an invalid value \"%s\"", count)
+	}
+
+	files := []*os.File{}
+	for idx := 0; idx < fds; idx++ {
+		files = append(files, os.NewFile(uintptr(listenFDsStart+idx), fmt.Sprintf("fd%d", listenFDsStart+idx)))
+	}
+	return socketsFromFiles(files, strings.Split(names, ":"))
+}
+
+// the configured listener that a socket stands in for, the one with the same port and transport. a socket
+// without a configured listener only uses the options that apply to every listener.
+func inheritedListener(listeners []ListenerConfig, s *socket) ListenerConfig {
+	host, portString, _ := net.SplitHostPort(s.addr().String())
+	port, _ := strconv.Atoi(portString)
+	for _, listenerConfig := range listeners {
+		if listenerConfig.Port != port {
+			continue
+		}
+		for _, transport := range listenerConfig.Transports {
+			if strings.EqualFold(transport, s.transport) {
+				match := listenerConfig
+				match.Host = host
+				match.Transports = []string{s.transport}
+				return match
+			}
+		}
+	}
+	return ListenerConfig{Host: host, Port: port, Transports: []string{s.transport}}
+}
+
+// starts a new copy of this process with the sockets that are being served. the new process tells this one
+// to stop (with SIGTERM) once it is serving them so that no questions go unanswered during an upgrade.
+func handoff() error {
+	socketLock.Lock()
+	sockets := append([]*socket{}, runningSockets...)
+	socketLock.Unlock()
+	if len(sockets) < 1 {
+		return fmt.Errorf("there are no sockets to hand over")
+	}
+
+	files := []*os.File{}
+	names := []string{}
+	defer func() {
+		// the new process has its own copies
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, s := range sockets {
+		file, err := s.file()
+		if err != nil {
+			return fmt.Errorf("the %s socket on %s could not be handed over: %s", s.transport, s.addr(), err)
+		}
+		files = append(files, file)
+		names = append(names, s.transport)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = []string{}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "LISTEN_") && !strings.HasPrefix(variable, parentPIDVariable+"=") {
+			cmd.Env = append(cmd.Env, variable)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("LISTEN_FDS=%d", len(files)),
+		fmt.Sprintf("LISTEN_FDNAMES=%s", strings.Join(names, ":")),
+		fmt.Sprintf("%s=%d", parentPIDVariable, os.Getpid()),
+	)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		err := cmd.Wait()
+		if !isStopping() {
+			logger.Error("The new process exited before taking over", logging.F("pid", cmd.Process.Pid), logging.F("error", err))
+		}
+	}()
+	logger.Info("Handing sockets over to a new process", logging.F("pid", cmd.Process.Pid))
+	return nil
+}
+
+// returns true if this process was handed its sockets by another process that hasn't been told to stop yet
+func handedOff() bool {
+	return os.Getenv(parentPIDVariable) != ""
+}
+
+// tells the process that handed its sockets over to stop now that they are being served here
+func finishHandoff() {
+	parent := os.Getenv(parentPIDVariable)
+	os.Unsetenv(parentPIDVariable)
+	if parent == "" {
+		return
+	}
+	pid, err := strconv.Atoi(parent)
+	if err != nil || pid != os.Getppid() {
+		return
+	}
+	if err := stopProcess(pid); err != nil {
+		logger.Error("The previous process could not be stopped", logging.F("pid", pid), logging.F("error", err))
+	}
+}
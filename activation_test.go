@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSocketsFromFiles(t *testing.T) {
+	udp, err := listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not bind udp socket: %s", err)
+	}
+	defer udp.packetConn.Close()
+	tcp, err := listen("dot", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not bind tcp socket: %s", err)
+	}
+	defer tcp.listener.Close()
+
+	files := []*os.File{}
+	for _, s := range []*socket{udp, tcp, tcp} {
+		file, err := s.file()
+		if err != nil {
+			t.Fatalf("Could not get the file for the %s socket: %s", s.transport, err)
+		}
+		files = append(files, file)
+	}
+
+	// names that don't fit the socket are ignored
+	sockets, err := socketsFromFiles(files, []string{"dot", "dot"})
+	if err != nil {
+		t.Fatalf("The files could not be used as sockets: %s", err)
+	}
+	expected := []string{"udp", "dot", "tcp"}
+	if len(sockets) != len(expected) {
+		t.Fatalf("The files did not become the expected sockets (was: %v)", sockets)
+	}
+	for idx, s := range sockets {
+		if s.transport != expected[idx] {
+			t.Errorf("The socket %d did not have the expected transport (was: %s, expected %s)", idx, s.transport, expected[idx])
+		}
+		if idx < 2 && s.addr().String() != []*socket{udp, tcp}[idx].addr().String() {
+			t.Errorf("The socket %d is not bound to the same address (was: %s)", idx, s.addr())
+		}
+		file, _ := s.file()
+		file.Close()
+	}
+
+	notSocket, _ := os.Open(os.DevNull)
+	if _, err := socketsFromFiles([]*os.File{notSocket}, nil); err == nil {
+		t.Errorf("A file that is not a socket should not be accepted")
+	}
+}
+
+func TestInheritedSockets(t *testing.T) {
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_PID")
+
+	// sockets for another process are left alone
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	if sockets, err := inheritedSockets(); err != nil || sockets != nil {
+		t.Errorf("Sockets for another process should not be used (was: %v, %v)", sockets, err)
+	}
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "none")
+	if _, err := inheritedSockets(); err == nil {
+		t.Errorf("An invalid LISTEN_FDS should not be accepted")
+	}
+	if _, found := os.LookupEnv("LISTEN_FDS"); found {
+		t.Errorf("LISTEN_FDS should not be passed on")
+	}
+}
+
+func TestInheritedListener(t *testing.T) {
+	udp, err := listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not bind udp socket: %s", err)
+	}
+	defer udp.packetConn.Close()
+	udpPort := udp.packetConn.LocalAddr().(*net.UDPAddr).Port
+
+	listeners := []ListenerConfig{
+		{Host: "0.0.0.0", Port: udpPort, Transports: []string{"TCP"}, Clients: ClientConfig{Denied: "drop"}},
+		{Host: "0.0.0.0", Port: udpPort, Transports: []string{"tcp", "UDP"}, Clients: ClientConfig{Denied: "refuse"}},
+	}
+	listenerConfig := inheritedListener(listeners, udp)
+	if listenerConfig.Host != "127.0.0.1" || listenerConfig.Port != udpPort || listenerConfig.Clients.Denied != "refuse" || len(listenerConfig.Transports) != 1 {
+		t.Errorf("The socket did not match the expected listener (was: %v)", listenerConfig)
+	}
+
+	listenerConfig = inheritedListener(listeners[:1], udp)
+	if listenerConfig.Clients.Denied != "" || listenerConfig.Transports[0] != "udp" {
+		t.Errorf("The socket should not have matched a listener (was: %v)", listenerConfig)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/chrisruffalo/gyip/logging"
+)
+
+// how long (beyond its grace period) a process that handed its sockets over is given to stop
+const adminHandoffWait = 5 * time.Second
+
+// how often binding the admin address is tried again while the process that handed its sockets over stops
+const adminRetryInterval = 250 * time.Millisecond
+
+// AdminConfig - options for the admin http server
+type AdminConfig struct {
+	// the address (host:port) the admin server listens on, the admin server is off without one
+	Address string `json:"address" yaml:"address" toml:"address"`
+	// the bearer token that the records api requires, the api is off without one
+	Token string `json:"token" yaml:"token" toml:"token"`
+}
+
+// the endpoints of the admin server
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsRegistry)
+	mux.HandleFunc("/healthz", serveHealthz)
+	mux.HandleFunc("/readyz", serveReadyz)
+	if recordsToken != "" {
+		api := recordsHandler()
+		mux.Handle(recordsPath, api)
+		mux.Handle(recordsPath+"/", api)
+		mux.Handle(snapshotPath, api)
+	}
+	return mux
+}
+
+// binds the admin address, trying again until the wait is over if it is in use. a process that was handed
+// sockets has to wait for the process that handed them over to stop before it can bind the admin address.
+func listenAdmin(address string, wait time.Duration) (net.Listener, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		listener, err := net.Listen("tcp", address)
+		if err == nil || !time.Now().Before(deadline) {
+			return listener, err
+		}
+		time.Sleep(adminRetryInterval)
+	}
+}
+
+// serves the admin endpoints on the listener until the server stops
+func serveAdmin(listener net.Listener) {
+	server := &http.Server{Handler: adminHandler()}
+	addRunningHTTPServer(server)
+	logger.Info("Starting admin server", logging.F("address", listener.Addr()))
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		logger.Error("The admin server failed", logging.F("address", listener.Addr()), logging.F("error", err))
+	}
+}
@@ -53,11 +53,17 @@ GOVERSION="1.10"
 GOLANG_CONTAINER_ROOT="/go/src/github.com/chrisruffalo/gyip"
 GOLANG_CONTAINER=$(buildah from golang:${GOVERSION}-alpine)
 buildah umount $GOLANG_CONTAINER # ensure unmounted
-buildah run $GOLANG_CONTAINER -- mkdir -p $GOLANG_CONTAINER_ROOT{,/command}
+buildah run $GOLANG_CONTAINER -- mkdir -p $GOLANG_CONTAINER_ROOT{,/command,/acl,/rrl,/logging,/metrics,/dnstap,/records}
 buildah config --workingdir "${GOLANG_CONTAINER_ROOT}" --env CGO_ENABLED="0" $GOLANG_CONTAINER
 buildah copy $GOLANG_CONTAINER .version $GOLANG_CONTAINER_ROOT
 buildah copy $GOLANG_CONTAINER *.go $GOLANG_CONTAINER_ROOT
 buildah copy $GOLANG_CONTAINER command/ $GOLANG_CONTAINER_ROOT/command
+buildah copy $GOLANG_CONTAINER acl/ $GOLANG_CONTAINER_ROOT/acl
+buildah copy $GOLANG_CONTAINER rrl/ $GOLANG_CONTAINER_ROOT/rrl
+buildah copy $GOLANG_CONTAINER logging/ $GOLANG_CONTAINER_ROOT/logging
+buildah copy $GOLANG_CONTAINER metrics/ $GOLANG_CONTAINER_ROOT/metrics
+buildah copy $GOLANG_CONTAINER dnstap/ $GOLANG_CONTAINER_ROOT/dnstap
+buildah copy $GOLANG_CONTAINER records/ $GOLANG_CONTAINER_ROOT/records
 buildah run $GOLANG_CONTAINER -- apk add --no-cache git > /dev/null 2>&1
 buildah run $GOLANG_CONTAINER -- go get
 buildah run $GOLANG_CONTAINER -- go build -a -tags netgo -ldflags "-w -X main.Version=${VERSION} -X main.GitHash=${GITHASH} -extldflags \"-static\"" -o gyip
@@ -71,7 +77,7 @@ BASE_CONTAINER=${BASE_CONTAINER:-"scratch"}
 GYIP_CONTAINER=$(buildah from ${BASE_CONTAINER})
 GYIP_CONTAINER_PATH="gyip/gyip"
 GYIP_CONTAINER_TAG="${GYIP_CONTAINER_PATH}:${BUILD_TAG}"
-buildah config --port 8053/tcp --port 8053/udp --workingdir "/" --entrypoint '["/gyip","--host 0.0.0.0"]' $GYIP_CONTAINER
+buildah config --port 8053/tcp --port 8053/udp --workingdir "/" --entrypoint '["/gyip"]' $GYIP_CONTAINER
 buildah copy $GYIP_CONTAINER "$TARGET/gyip" /gyip
 buildah commit $GYIP_CONTAINER $GYIP_CONTAINER_TAG
 # more tags
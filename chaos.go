@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the CHAOS class names that identify the server
+const (
+	chaosVersionBind   = "version.bind."
+	chaosVersionServer = "version.server."
+	chaosHostnameBind  = "hostname.bind."
+	chaosIDServer      = "id.server."
+	chaosStats         = "stats.gyip."
+)
+
+// when the server was started, for the uptime in the stats
+var startTime = time.Now()
+
+// the answers to CHAOS class questions (from the combined configuration file and command line)
+var chaosConfig ChaosConfig
+
+// ChaosConfig - options for the CHAOS class TXT questions that identify the server
+type ChaosConfig struct {
+	// CHAOS class questions are not in the zone like any other name
+	Off bool `json:"off" yaml:"off" toml:"off"`
+	// the answer to version.bind and version.server, defaults to the gyip version
+	Version string `json:"version" yaml:"version" toml:"version"`
+	// the answer to hostname.bind and id.server, defaults to the host name
+	ID string `json:"id" yaml:"id" toml:"id"`
+}
+
+// wraps the handler so that CHAOS class questions for version.bind, version.server, hostname.bind, id.server,
+// and stats.gyip are answered with what the server is and how it is doing. every other question goes on.
+func chaos(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if chaosConfig.Off || len(r.Question) < 1 || r.Question[0].Qclass != dns.ClassCHAOS {
+			next.ServeDNS(w, r)
+			return
+		}
+		q := r.Question[0]
+		lines := chaosAnswer(strings.ToLower(q.Name))
+		if lines == nil {
+			next.ServeDNS(w, r)
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Compress = compressReplies
+		m.Authoritative = true
+		// other types of question for the names have no answers
+		if q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0},
+				Txt: lines,
+			})
+		}
+		w.WriteMsg(m)
+	})
+}
+
+// the strings of the TXT answer for the name, nil when it is not one of the CHAOS names
+func chaosAnswer(name string) []string {
+	switch name {
+	case chaosVersionBind, chaosVersionServer:
+		if chaosConfig.Version != "" {
+			return []string{chaosConfig.Version}
+		}
+		return []string{"gyip " + LongVersion}
+	case chaosHostnameBind, chaosIDServer:
+		if chaosConfig.ID != "" {
+			return []string{chaosConfig.ID}
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			return []string{"unknown"}
+		}
+		return []string{hostname}
+	case chaosStats:
+		return chaosStatistics()
+	}
+	return nil
+}
+
+// the uptime and the question counts by response code and transport, one for each string
+func chaosStatistics() []string {
+	total := queryCounter.Totals("")[""]
+	lines := []string{
+		fmt.Sprintf("uptime: %s", time.Since(startTime).Truncate(time.Second)),
+		fmt.Sprintf("queries: %.0f", total),
+	}
+	for _, label := range []string{"rcode", "transport"} {
+		totals := queryCounter.Totals(label)
+		values := []string{}
+		for value := range totals {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			lines = append(lines, fmt.Sprintf("%s %s: %.0f", label, value, totals[value]))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestChaos(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	previousLogger := queryLogger
+	queryLogger = nil
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		queryLogger = previousLogger
+		chaosConfig = ChaosConfig{}
+	}()
+	hostname, _ := os.Hostname()
+
+	// a question is counted so that the stats have something in them
+	handler := observe(chaos(domainMux(nil)), "udp")
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.0.0.100"), Port: 5353}}
+	question := new(dns.Msg)
+	question.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+	handler.ServeDNS(w, question)
+
+	data := []struct {
+		config   ChaosConfig
+		name     string
+		class    uint16
+		qtype    uint16
+		rcode    int
+		expected []string
+	}{
+		{ChaosConfig{}, "version.bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, []string{"gyip " + LongVersion}},
+		{ChaosConfig{}, "VERSION.SERVER.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, []string{"gyip " + LongVersion}},
+		{ChaosConfig{}, "hostname.bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, []string{hostname}},
+		{ChaosConfig{}, "id.server.", dns.ClassCHAOS, dns.TypeANY, dns.RcodeSuccess, []string{hostname}},
+		{ChaosConfig{Version: "hidden", ID: "dns-1"}, "version.bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, []string{"hidden"}},
+		{ChaosConfig{Version: "hidden", ID: "dns-1"}, "id.server.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, []string{"dns-1"}},
+		{ChaosConfig{}, "stats.gyip.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, []string{"uptime: ", "queries: ", "rcode NOERROR: ", "transport udp: "}},
+		// other types have no answers
+		{ChaosConfig{}, "version.bind.", dns.ClassCHAOS, dns.TypeA, dns.RcodeSuccess, []string{}},
+		// other names, classes, or with chaos off are not in the zone
+		{ChaosConfig{}, "other.bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeNotZone, []string{}},
+		{ChaosConfig{}, "version.bind.", dns.ClassINET, dns.TypeTXT, dns.RcodeNotZone, []string{}},
+		{ChaosConfig{Off: true}, "version.bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeNotZone, []string{}},
+	}
+
+	for _, item := range data {
+		chaosConfig = item.config
+		question := new(dns.Msg)
+		question.SetQuestion(item.name, item.qtype)
+		question.Question[0].Qclass = item.class
+		handler.ServeDNS(w, question)
+
+		if w.written.Rcode != item.rcode {
+			t.Errorf("The question for '%s' was answered with %s instead of %s", item.name, dns.RcodeToString[w.written.Rcode], dns.RcodeToString[item.rcode])
+		}
+		lines := []string{}
+		for _, rr := range w.written.Answer {
+			if rr.Header().Class != dns.ClassCHAOS {
+				t.Errorf("The answer for '%s' was not in the CHAOS class", item.name)
+			}
+			lines = append(lines, rr.(*dns.TXT).Txt...)
+		}
+		if len(item.expected) < 1 && len(lines) > 0 {
+			t.Errorf("The question for '%s' should not have been answered (was: %v)", item.name, lines)
+		}
+		// the stats have a line for each response code and transport that has been counted by any test
+		for _, expected := range item.expected {
+			found := false
+			for _, line := range lines {
+				found = found || strings.HasPrefix(line, expected)
+			}
+			if !found {
+				t.Errorf("The answer for '%s' did not have '%s' (was: %v)", item.name, expected, lines)
+			}
+		}
+	}
+}
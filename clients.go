@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/chrisruffalo/gyip/acl"
+	"github.com/miekg/dns"
+)
+
+// what can be done with a question from a client that is denied
+const (
+	clientRefuse = "refuse"
+	clientDrop   = "drop"
+)
+
+var clientActions = []string{clientRefuse, clientDrop}
+
+// ClientConfig - the clients that can ask questions and what happens to the questions from clients that cannot
+type ClientConfig struct {
+	Allow []string `json:"allow" yaml:"allow" toml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny" toml:"deny"`
+	// what happens to questions from clients that are denied: "refuse" (the default) answers with REFUSED
+	// and "drop" sends nothing back
+	Denied string `json:"denied" yaml:"denied" toml:"denied"`
+
+	// built from the allowed and denied networks when the configuration is finished
+	clients *acl.List
+}
+
+// builds the list of clients from the allowed and denied networks
+func (clientConfig *ClientConfig) build() error {
+	clients, err := acl.New(clientConfig.Allow, clientConfig.Deny)
+	if err != nil {
+		return err
+	}
+	clientConfig.clients = clients
+	return nil
+}
+
+// checks the client that sent the request. when the client is not permitted the request is
+// refused or dropped and false is returned. the questions the server asks itself are always permitted.
+func (clientConfig *ClientConfig) admit(w dns.ResponseWriter, r *dns.Msg) bool {
+	if clientConfig == nil || isReadinessQuestion(r) || clientConfig.clients.Permits(remoteIP(w.RemoteAddr())) {
+		return true
+	}
+
+	if strings.ToLower(clientConfig.Denied) == clientDrop {
+		// closes tcp connections, udp has nothing to close
+		w.Close()
+		return false
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeRefused)
+	m.Compress = compressReplies
+	w.WriteMsg(m)
+	return false
+}
+
+// wraps the handler so that each of the client configurations is checked before the handler is used
+func clientFilter(next dns.Handler, clientConfigs ...*ClientConfig) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		for _, clientConfig := range clientConfigs {
+			if !clientConfig.admit(w, r) {
+				return
+			}
+		}
+		next.ServeDNS(w, r)
+	})
+}
+
+// the ip of the remote end of the connection
+func remoteIP(addr net.Addr) net.IP {
+	switch remoteAddr := addr.(type) {
+	case *net.UDPAddr:
+		return remoteAddr.IP
+	case *net.TCPAddr:
+		return remoteAddr.IP
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestClientFilter(t *testing.T) {
+	everywhere := &ClientConfig{Deny: []string{"10.0.5.0/24"}}
+	listener := &ClientConfig{Allow: []string{"10.0.0.0/8", "::1"}, Denied: "drop"}
+	for _, clientConfig := range []*ClientConfig{everywhere, listener} {
+		if err := clientConfig.build(); err != nil {
+			t.Fatalf("Could not build client config: %s", err)
+		}
+	}
+
+	answered := false
+	handler := clientFilter(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		answered = true
+	}), everywhere, listener)
+
+	data := []struct {
+		remote   net.Addr
+		answered bool
+		refused  bool
+		dropped  bool
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, true, false, false},
+		{&net.TCPAddr{IP: net.ParseIP("::1")}, true, false, false},
+		{&net.UDPAddr{IP: net.ParseIP("10.0.5.1")}, false, true, false},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}, false, false, true},
+	}
+
+	for _, item := range data {
+		answered = false
+		w := &testWriter{remote: item.remote}
+		r := new(dns.Msg)
+		r.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+		handler.ServeDNS(w, r)
+
+		if answered != item.answered {
+			t.Errorf("The client %s was not answered as expected (was: %t, expected %t)", item.remote, answered, item.answered)
+		}
+		if refused := w.written != nil && w.written.Rcode == dns.RcodeRefused; refused != item.refused {
+			t.Errorf("The client %s was not refused as expected (was: %t, expected %t)", item.remote, refused, item.refused)
+		}
+		if dropped := w.written == nil && w.closed; dropped != item.dropped {
+			t.Errorf("The client %s was not dropped as expected (was: %t, expected %t)", item.remote, dropped, item.dropped)
+		}
+	}
+}
+
+func TestDomainClients(t *testing.T) {
+	labs := &DomainConfig{Name: "labs.io.", Clients: ClientConfig{Allow: []string{"10.10.0.0/16"}}}
+	if err := labs.Clients.build(); err != nil {
+		t.Fatalf("Could not build client config: %s", err)
+	}
+	servingDomains = []*DomainConfig{labs, {Name: "open.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	data := []struct {
+		question string
+		remote   string
+		rcode    int
+	}{
+		{"10.0.0.1.labs.io.", "10.10.1.1", dns.RcodeSuccess},
+		{"10.0.0.1.labs.io.", "10.20.1.1", dns.RcodeRefused},
+		{"10.0.0.1.open.io.", "10.20.1.1", dns.RcodeSuccess},
+		// the domain is matched without regard to case
+		{"10.0.0.1.f50.Labs.IO.", "8.8.8.8", dns.RcodeRefused},
+		{"10.0.0.1.LABS.io.", "10.10.1.1", dns.RcodeSuccess},
+		// the questions the server asks itself are let through, guessed labels are not
+		{readinessLabel + ".labs.io.", "127.0.0.1", dns.RcodeNameError},
+		{"_readyz-00000000000000000000000000000000.labs.io.", "127.0.0.1", dns.RcodeRefused},
+	}
+
+	for _, item := range data {
+		w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP(item.remote)}}
+		r := new(dns.Msg)
+		r.SetQuestion(item.question, dns.TypeA)
+		handleQuestions(w, r)
+		if w.written == nil || w.written.Rcode != item.rcode {
+			t.Errorf("The question '%s' from %s did not get the expected response code %d (was: %v)", item.question, item.remote, item.rcode, w.written)
+		}
+	}
+
+	// a question for a domain the client can ask about doesn't let it ask about the others
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.20.1.1")}}
+	r := new(dns.Msg)
+	r.SetQuestion("10.0.0.1.open.io.", dns.TypeA)
+	r.Question = append(r.Question, dns.Question{Name: "10.0.0.1.labs.io.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	handleQuestions(w, r)
+	if w.written == nil || w.written.Rcode != dns.RcodeRefused || len(w.written.Answer) != 0 {
+		t.Errorf("The questions should have been refused because of the second domain (was: %v)", w.written)
+	}
+}
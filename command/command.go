@@ -26,6 +26,29 @@ const (
 	FAIL
 )
 
+// the names used for each command type in configuration
+var typeNames = map[Type]string{
+	NOOP: "noop",
+	RR:   "rr",
+	FAIL: "fail",
+}
+
+// String - the name used for the command type in configuration
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+// TypeFromString - finds the command type with the given configuration name
+func TypeFromString(name string) (Type, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for cmdType, typeName := range typeNames {
+		if typeName == name {
+			return cmdType, true
+		}
+	}
+	return NOOP, false
+}
+
 // New - factory to create command from string
 func New(commandString string) Command {
 	if commandString == "" {
@@ -1,6 +1,9 @@
 package command
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestCommandFactory(t *testing.T) {
 	data := []struct {
@@ -23,3 +26,28 @@ func TestCommandFactory(t *testing.T) {
 	}
 
 }
+
+func TestTypeFromString(t *testing.T) {
+	data := []struct {
+		name  string
+		eType Type
+		found bool
+	}{
+		{"rr", RR, true},
+		{"RR", RR, true},
+		{" fail ", FAIL, true},
+		{"noop", NOOP, true},
+		{"f50", NOOP, false},
+		{"", NOOP, false},
+	}
+
+	for _, item := range data {
+		cmdType, found := TypeFromString(item.name)
+		if item.found != found || item.eType != cmdType {
+			t.Errorf("Type name '%s' did not return expected type (was: %v/%t, expected %v/%t)", item.name, cmdType, found, item.eType, item.found)
+		}
+		if found && cmdType.String() != strings.ToLower(strings.TrimSpace(item.name)) {
+			t.Errorf("Type %v did not return the expected name (was: %s)", cmdType, cmdType.String())
+		}
+	}
+}
@@ -0,0 +1,854 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/chrisruffalo/gyip/acl"
+	"github.com/chrisruffalo/gyip/command"
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v2"
+)
+
+// the transports that a listener can provide
+var validTransports = []string{"udp", "tcp", "dot", "doh"}
+
+// the transports that a listener provides when none are given
+var defaultTransports = []string{"udp", "tcp"}
+
+// the port used by dns over tls listeners when none is given
+const defaultDoTPort = 853
+
+// the port used by dns over https listeners when none is given
+const defaultDoHPort = 443
+
+// Config - all of the options that can be given in a configuration file. the command line flags are
+// shorthand for the most commonly used parts of this structure.
+type Config struct {
+	Domains   []DomainConfig   `json:"domains" yaml:"domains" toml:"domains"`
+	Listeners []ListenerConfig `json:"listeners" yaml:"listeners" toml:"listeners"`
+	Compress  bool             `json:"compress" yaml:"compress" toml:"compress"`
+	Logging   LoggingConfig    `json:"logging" yaml:"logging" toml:"logging"`
+	// the answer addresses used by domains that do not give their own
+	Answers AddressConfig `json:"answers" yaml:"answers" toml:"answers"`
+	// the clients that can ask questions of any listener or domain
+	Clients ClientConfig `json:"clients" yaml:"clients" toml:"clients"`
+	// limits the rate of udp responses to each client
+	RateLimit RateLimitConfig `json:"rateLimit" yaml:"rateLimit" toml:"rateLimit"`
+	// options for edns0
+	EDNS EDNSConfig `json:"edns" yaml:"edns" toml:"edns"`
+	// the certificate used by encrypted (dot and doh) listeners
+	TLS TLSConfig `json:"tls" yaml:"tls" toml:"tls"`
+	// options for stopping the server
+	Shutdown ShutdownConfig `json:"shutdown" yaml:"shutdown" toml:"shutdown"`
+	// the admin http server (metrics, health, and readiness)
+	Admin AdminConfig `json:"admin" yaml:"admin" toml:"admin"`
+	// where registered records are kept
+	Records RecordsConfig `json:"records" yaml:"records" toml:"records"`
+	// writes every question and response as a dnstap message
+	Dnstap DnstapConfig `json:"dnstap" yaml:"dnstap" toml:"dnstap"`
+	// the CHAOS class TXT questions that identify the server
+	Chaos ChaosConfig `json:"chaos" yaml:"chaos" toml:"chaos"`
+	// keep running when some (but not all) of the listeners cannot be started
+	AllowPartial bool `json:"allowPartial" yaml:"allowPartial" toml:"allowPartial"`
+}
+
+// DomainConfig - a served domain and the options that apply only to it
+type DomainConfig struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// the longest ttl given to answers in this domain, commands that need short-lived
+	// answers (rr, fNN) keep their shorter ttl. zero leaves the command ttl alone.
+	TTL uint32 `json:"ttl" yaml:"ttl" toml:"ttl"`
+	// the keywords (echo, reflect, explain) that are honored for this domain. when not given all keywords are honored.
+	Keywords []string `json:"keywords" yaml:"keywords" toml:"keywords"`
+	// the commands that are honored for this domain. when not given all commands are honored.
+	Commands []string `json:"commands" yaml:"commands" toml:"commands"`
+	// the address encodings (ipv4, ipv6) that are honored for this domain. when not given all encodings are honored.
+	Encodings []string `json:"encodings" yaml:"encodings" toml:"encodings"`
+	// what happens to questions that use something that is not honored: "ignore" (the default) answers
+	// as if it was not there and "refuse" answers with REFUSED
+	Disallowed string `json:"disallowed" yaml:"disallowed" toml:"disallowed"`
+	// the addresses that can be given in answers, anything not given here comes from the top level answers
+	Answers AddressConfig `json:"answers" yaml:"answers" toml:"answers"`
+	// the clients that can ask questions about this domain
+	Clients ClientConfig `json:"clients" yaml:"clients" toml:"clients"`
+
+	// built from the answer addresses when the configuration is finished
+	answers *acl.List
+}
+
+// AddressConfig - a preset list of networks along with networks that are allowed or denied on top of it
+type AddressConfig struct {
+	// the name of the preset that the lists start with
+	Preset string `json:"preset" yaml:"preset" toml:"preset"`
+	// replaces the networks that the preset allows
+	Allow []string `json:"allow" yaml:"allow" toml:"allow"`
+	// denied in addition to the networks that the preset denies
+	Deny []string `json:"deny" yaml:"deny" toml:"deny"`
+}
+
+// ListenerConfig - an address and port along with the transports that are served there
+type ListenerConfig struct {
+	Host       string   `json:"host" yaml:"host" toml:"host"`
+	Port       int      `json:"port" yaml:"port" toml:"port"`
+	Transports []string `json:"transports" yaml:"transports" toml:"transports"`
+	// the number of sockets opened for each address and transport, more than one are shared with SO_REUSEPORT
+	Sockets int `json:"sockets" yaml:"sockets" toml:"sockets"`
+	// the domains answered on this listener, all of the domains when left out
+	Domains []string `json:"domains" yaml:"domains" toml:"domains"`
+	// the clients that can ask questions on this listener
+	Clients ClientConfig `json:"clients" yaml:"clients" toml:"clients"`
+}
+
+// configError - a problem found in the configuration along with the line it was found on (when known)
+type configError struct {
+	line    int
+	message string
+}
+
+// configErrors - every problem found while loading a configuration file
+type configErrors struct {
+	file   string
+	errors []configError
+}
+
+func (e *configErrors) Error() string {
+	lines := make([]string, 0, len(e.errors))
+	for _, err := range e.errors {
+		if err.line > 0 {
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", e.file, err.line, err.message))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s", e.file, err.message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validationError - a problem found by validating the configuration values, the token is the
+// text that is searched for in the source file to find the line the problem is on
+type validationError struct {
+	token   string
+	message string
+}
+
+// matches the line numbers reported by the yaml parser
+var yamlLineMatcher = regexp.MustCompile(`line (\d+): (.*)$`)
+
+// matches the line numbers reported by the toml parser
+var tomlLineMatcher = regexp.MustCompile(`^Near line (\d+) \(last key parsed '[^']*'\): (.*)$`)
+
+// matches the unknown field name in json errors
+var jsonFieldMatcher = regexp.MustCompile(`^json: unknown field "([^"]*)"$`)
+
+// the configuration that exists when no file or flags are given
+func defaultConfig() *Config {
+	return &Config{
+		Logging: LoggingConfig{
+			Queries: true,
+			Level:   "info",
+			Format:  "text",
+		},
+		Answers: AddressConfig{
+			Preset: defaultAnswerPreset,
+		},
+		RateLimit: RateLimitConfig{
+			Window:     15,
+			Slip:       2,
+			IPv4Prefix: 24,
+			IPv6Prefix: 56,
+		},
+		EDNS: EDNSConfig{
+			BufferSize: defaultEDNSBufferSize,
+		},
+		Shutdown: ShutdownConfig{
+			GracePeriod: defaultGracePeriod,
+		},
+	}
+}
+
+// loads the configuration file at the given path, the format is chosen by the file extension
+func loadConfig(path string) (*Config, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	var errs []configError
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		errs = decodeYAML(source, cfg)
+	case ".toml":
+		errs = decodeTOML(source, cfg)
+	case ".json":
+		errs = decodeJSON(source, cfg)
+	default:
+		return nil, fmt.Errorf("the configuration file \"%s\" must end in .yaml, .yml, .toml, or .json", path)
+	}
+
+	// only validate values once they could all be read
+	if len(errs) < 1 {
+		for _, verr := range validateConfig(cfg) {
+			errs = append(errs, configError{line: lineOf(source, verr.token), message: verr.message})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, &configErrors{file: path, errors: errs}
+	}
+
+	return cfg, nil
+}
+
+func decodeYAML(source []byte, cfg *Config) []configError {
+	err := yaml.UnmarshalStrict(source, cfg)
+	if err == nil {
+		return nil
+	}
+
+	// type errors contain one entry for each problem
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+
+	errs := []configError{}
+	for _, message := range messages {
+		if match := yamlLineMatcher.FindStringSubmatch(message); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			errs = append(errs, configError{line: line, message: match[2]})
+		} else {
+			errs = append(errs, configError{message: message})
+		}
+	}
+	return errs
+}
+
+func decodeTOML(source []byte, cfg *Config) []configError {
+	meta, err := toml.Decode(string(source), cfg)
+	if err != nil {
+		if match := tomlLineMatcher.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			return []configError{{line: line, message: match[2]}}
+		}
+		return []configError{{message: err.Error()}}
+	}
+
+	// toml leaves unknown keys alone instead of failing
+	errs := []configError{}
+	for _, key := range meta.Undecoded() {
+		name := key[len(key)-1]
+		errs = append(errs, configError{line: lineOf(source, name), message: fmt.Sprintf("unknown field \"%s\"", key)})
+	}
+	return errs
+}
+
+func decodeJSON(source []byte, cfg *Config) []configError {
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(cfg)
+	if err == nil {
+		return nil
+	}
+
+	switch jsonErr := err.(type) {
+	case *json.SyntaxError:
+		return []configError{{line: lineAt(source, jsonErr.Offset), message: jsonErr.Error()}}
+	case *json.UnmarshalTypeError:
+		return []configError{{line: lineAt(source, jsonErr.Offset), message: fmt.Sprintf("cannot use %s as %s for field \"%s\"", jsonErr.Value, jsonErr.Type, jsonErr.Field)}}
+	}
+
+	if match := jsonFieldMatcher.FindStringSubmatch(err.Error()); match != nil {
+		return []configError{{line: lineOf(source, "\""+match[1]+"\""), message: fmt.Sprintf("unknown field \"%s\"", match[1])}}
+	}
+	return []configError{{message: err.Error()}}
+}
+
+// the line that the byte offset falls on
+func lineAt(source []byte, offset int64) int {
+	if offset > int64(len(source)) {
+		offset = int64(len(source))
+	}
+	return bytes.Count(source[:offset], []byte("\n")) + 1
+}
+
+// the line that the token is on, or 0 if it cannot be found or is in the file more than once. the parsers
+// don't say where each value came from so a line is only given when it can't be the wrong one.
+func lineOf(source []byte, token string) int {
+	if token == "" {
+		return 0
+	}
+	line := 0
+	for offset := 0; offset < len(source); {
+		index := bytes.Index(source[offset:], []byte(token))
+		if index < 0 {
+			break
+		}
+		start := offset + index
+		end := start + len(token)
+		offset = start + 1
+		// only whole values count, 10.0.0.1 is not in 10.0.0.10
+		if (start > 0 && isTokenByte(source[start-1])) || (end < len(source) && isTokenByte(source[end])) {
+			continue
+		}
+		if line > 0 {
+			return 0
+		}
+		line = lineAt(source, int64(start))
+	}
+	return line
+}
+
+// returns true if the byte can be part of a name, number, or address
+func isTokenByte(b byte) bool {
+	return b == '_' || b == '-' || b == '.' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// checks the values in the configuration, this does not require that the configuration is complete
+// because the command line can still provide values that are missing
+func validateConfig(cfg *Config) []validationError {
+	errs := []validationError{}
+
+	for idx, domainConfig := range cfg.Domains {
+		field := fmt.Sprintf("domains[%d]", idx)
+		if strings.TrimSpace(domainConfig.Name) == "" {
+			errs = append(errs, validationError{token: "name", message: field + ".name: a domain name is required"})
+		} else if !checkDomain(domainConfig.Name) {
+			errs = append(errs, validationError{token: domainConfig.Name, message: fmt.Sprintf("%s.name: \"%s\" is not a valid domain", field, domainConfig.Name)})
+		}
+		for _, keyword := range domainConfig.Keywords {
+			if !containsString(policyKeywords, strings.ToLower(keyword)) {
+				errs = append(errs, validationError{token: keyword, message: fmt.Sprintf("%s.keywords: \"%s\" is not one of %v", field, keyword, policyKeywords)})
+			}
+		}
+		for _, commandName := range domainConfig.Commands {
+			if _, found := command.TypeFromString(commandName); !found {
+				errs = append(errs, validationError{token: commandName, message: fmt.Sprintf("%s.commands: \"%s\" is not a known command", field, commandName)})
+			}
+		}
+		for _, encoding := range domainConfig.Encodings {
+			if !containsString(policyEncodings, strings.ToLower(encoding)) {
+				errs = append(errs, validationError{token: encoding, message: fmt.Sprintf("%s.encodings: \"%s\" is not one of %v", field, encoding, policyEncodings)})
+			}
+		}
+		if domainConfig.Disallowed != "" && !containsString(policyActions, strings.ToLower(domainConfig.Disallowed)) {
+			errs = append(errs, validationError{token: domainConfig.Disallowed, message: fmt.Sprintf("%s.disallowed: \"%s\" is not one of %v", field, domainConfig.Disallowed, policyActions)})
+		}
+		errs = append(errs, validateAddresses(field+".answers", domainConfig.Answers)...)
+		errs = append(errs, validateClients(field+".clients", domainConfig.Clients)...)
+	}
+
+	errs = append(errs, validateAddresses("answers", cfg.Answers)...)
+	errs = append(errs, validateClients("clients", cfg.Clients)...)
+
+	if cfg.RateLimit.ResponsesPerSecond < 0 {
+		errs = append(errs, validationError{token: "responsesPerSecond", message: "rateLimit.responsesPerSecond: must not be negative"})
+	}
+	if cfg.RateLimit.ResponsesPerSecond > 0 {
+		if cfg.RateLimit.Window < 1 {
+			errs = append(errs, validationError{token: "window", message: "rateLimit.window: must be at least one second"})
+		}
+		if cfg.RateLimit.Slip < 0 {
+			errs = append(errs, validationError{token: "slip", message: "rateLimit.slip: must not be negative"})
+		}
+		if cfg.RateLimit.IPv4Prefix < 1 || cfg.RateLimit.IPv4Prefix > 32 {
+			errs = append(errs, validationError{token: "ipv4Prefix", message: fmt.Sprintf("rateLimit.ipv4Prefix: %d is not between 1 and 32", cfg.RateLimit.IPv4Prefix)})
+		}
+		if cfg.RateLimit.IPv6Prefix < 1 || cfg.RateLimit.IPv6Prefix > 128 {
+			errs = append(errs, validationError{token: "ipv6Prefix", message: fmt.Sprintf("rateLimit.ipv6Prefix: %d is not between 1 and 128", cfg.RateLimit.IPv6Prefix)})
+		}
+	}
+
+	if cfg.EDNS.BufferSize != 0 && cfg.EDNS.BufferSize < dns.MinMsgSize {
+		errs = append(errs, validationError{token: "bufferSize", message: fmt.Sprintf("edns.bufferSize: must be at least %d", dns.MinMsgSize)})
+	}
+
+	if cfg.Logging.Level != "" && !containsString(logLevels, strings.ToLower(cfg.Logging.Level)) {
+		errs = append(errs, validationError{token: cfg.Logging.Level, message: fmt.Sprintf("logging.level: \"%s\" is not one of %v", cfg.Logging.Level, logLevels)})
+	}
+	if cfg.Logging.Format != "" && !containsString(logFormats, strings.ToLower(cfg.Logging.Format)) {
+		errs = append(errs, validationError{token: cfg.Logging.Format, message: fmt.Sprintf("logging.format: \"%s\" is not one of %v", cfg.Logging.Format, logFormats)})
+	}
+
+	if cfg.Logging.QuerySample < 0 {
+		errs = append(errs, validationError{token: "querySample", message: "logging.querySample: must not be negative"})
+	}
+	if cfg.Logging.QueryFile.MaxSize < 0 {
+		errs = append(errs, validationError{token: "maxSize", message: "logging.queryFile.maxSize: must not be negative"})
+	}
+	if cfg.Logging.QueryFile.MaxAge < 0 {
+		errs = append(errs, validationError{token: "maxAge", message: "logging.queryFile.maxAge: must not be negative"})
+	}
+	if cfg.Logging.QueryFile.Keep < 0 {
+		errs = append(errs, validationError{token: "keep", message: "logging.queryFile.keep: must not be negative"})
+	}
+
+	if cfg.Admin.Address != "" {
+		if _, adminPort, err := net.SplitHostPort(cfg.Admin.Address); err != nil {
+			errs = append(errs, validationError{token: cfg.Admin.Address, message: fmt.Sprintf("admin.address: \"%s\" is not a host:port address", cfg.Admin.Address)})
+		} else if number, err := strconv.Atoi(adminPort); err != nil || number < 0 || number > 65535 {
+			errs = append(errs, validationError{token: cfg.Admin.Address, message: fmt.Sprintf("admin.address: \"%s\" is not a valid port", adminPort)})
+		}
+	}
+	if cfg.Admin.Token != "" && cfg.Admin.Address == "" {
+		errs = append(errs, validationError{token: "token", message: "admin.token: the records api needs an admin address"})
+	}
+
+	if cfg.Dnstap.Socket != "" && cfg.Dnstap.File != "" {
+		errs = append(errs, validationError{token: "file", message: "dnstap: only one of socket and file can be given"})
+	}
+
+	if cfg.Shutdown.GracePeriod < 0 {
+		errs = append(errs, validationError{token: "gracePeriod", message: "shutdown.gracePeriod: must not be negative"})
+	}
+
+	for idx, listenerConfig := range cfg.Listeners {
+		field := fmt.Sprintf("listeners[%d]", idx)
+		if listenerConfig.Port < 0 || listenerConfig.Port > 65535 {
+			errs = append(errs, validationError{token: strconv.Itoa(listenerConfig.Port), message: fmt.Sprintf("%s.port: %d is not a valid port", field, listenerConfig.Port)})
+		}
+		for _, transport := range listenerConfig.Transports {
+			if !containsString(validTransports, strings.ToLower(transport)) {
+				errs = append(errs, validationError{token: transport, message: fmt.Sprintf("%s.transports: \"%s\" is not one of %v", field, transport, validTransports)})
+			}
+		}
+		for _, name := range listenerConfig.Domains {
+			if !servesDomain(cfg.Domains, name) {
+				errs = append(errs, validationError{token: name, message: fmt.Sprintf("%s.domains: \"%s\" is not one of the served domains", field, name)})
+			}
+		}
+		if listenerConfig.Sockets < 0 {
+			errs = append(errs, validationError{token: "sockets", message: fmt.Sprintf("%s.sockets: must not be negative", field)})
+		}
+		errs = append(errs, validateClients(field+".clients", listenerConfig.Clients)...)
+	}
+
+	return errs
+}
+
+// the prefix for environment variables that set flags
+const environmentPrefix = "GYIP_"
+
+// the environment variable that can be used to set the named flag, ex: "tcpOff" is set by "GYIP_TCP_OFF"
+func environmentName(flagName string) string {
+	name := environmentPrefix
+	for idx, char := range flagName {
+		if idx > 0 && unicode.IsUpper(char) {
+			name += "_"
+		}
+		name += string(unicode.ToUpper(char))
+	}
+	return name
+}
+
+// sets every flag that was not given on the command line from its environment variable, this
+// happens before the flags are applied so that the environment also overrides the configuration file
+func applyEnvironment(flags *flag.FlagSet) error {
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || setFlags[f.Name] {
+			return
+		}
+		envName := environmentName(f.Name)
+		if value, found := os.LookupEnv(envName); found {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("the environment variable %s has an invalid value \"%s\": %s", envName, value, setErr)
+			}
+		}
+	})
+
+	return err
+}
+
+// checks the preset name and each network in the address configuration
+func validateAddresses(field string, addresses AddressConfig) []validationError {
+	errs := []validationError{}
+	if _, found := answerPresets[strings.ToLower(addresses.Preset)]; addresses.Preset != "" && !found {
+		errs = append(errs, validationError{token: addresses.Preset, message: fmt.Sprintf("%s.preset: \"%s\" is not one of %v", field, addresses.Preset, answerPresetNames())})
+	}
+	for _, cidr := range append(append([]string{}, addresses.Allow...), addresses.Deny...) {
+		if _, err := acl.ParseNetworks([]string{cidr}); err != nil {
+			errs = append(errs, validationError{token: cidr, message: fmt.Sprintf("%s: %s", field, err)})
+		}
+	}
+	return errs
+}
+
+// checks each network in the client configuration along with the action for denied clients
+func validateClients(field string, clients ClientConfig) []validationError {
+	errs := []validationError{}
+	if clients.Denied != "" && !containsString(clientActions, strings.ToLower(clients.Denied)) {
+		errs = append(errs, validationError{token: clients.Denied, message: fmt.Sprintf("%s.denied: \"%s\" is not one of %v", field, clients.Denied, clientActions)})
+	}
+	for _, cidr := range append(append([]string{}, clients.Allow...), clients.Deny...) {
+		if _, err := acl.ParseNetworks([]string{cidr}); err != nil {
+			errs = append(errs, validationError{token: cidr, message: fmt.Sprintf("%s: %s", field, err)})
+		}
+	}
+	return errs
+}
+
+// overrides the configuration with any flags that were given on the command line
+func applyFlags(cfg *Config) error {
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	// the domain flag replaces the configured domains but keeps the options of any
+	// domains that are in both
+	if setFlags["domain"] {
+		domains := []DomainConfig{}
+		for _, name := range splitDomains(*domain) {
+			name = dnsName(name)
+			domainConfig := DomainConfig{Name: name}
+			for _, configured := range cfg.Domains {
+				if dnsName(configured.Name) == name {
+					domainConfig = configured
+					domainConfig.Name = name
+					break
+				}
+			}
+			domains = append(domains, domainConfig)
+		}
+		cfg.Domains = domains
+	}
+
+	// listener definitions replace the configured listeners, otherwise any of the shorthand listener flags do
+	if setFlags["listen"] {
+		cfg.Listeners = []ListenerConfig{}
+		for _, spec := range listenSpecs {
+			listenerConfig, err := parseListener(spec)
+			if err != nil {
+				return err
+			}
+			cfg.Listeners = append(cfg.Listeners, listenerConfig)
+		}
+	} else if setFlags["host"] || setFlags["port"] || setFlags["tcpOff"] || setFlags["udpOff"] || len(cfg.Listeners) < 1 {
+		listenPort, err := strconv.Atoi(*port)
+		if err != nil || listenPort < 1 || listenPort > 65535 {
+			return fmt.Errorf("the port \"%s\" is not a valid port", *port)
+		}
+		// can't do anything if both tcp and udp are off
+		if *tcpOff && *udpOff {
+			return fmt.Errorf("the options tcpOff and udpOff cannot both be set at the same time")
+		}
+		transports := []string{}
+		if !*tcpOff {
+			transports = append(transports, "tcp")
+		}
+		if !*udpOff {
+			transports = append(transports, "udp")
+		}
+		cfg.Listeners = []ListenerConfig{{Host: *hosts, Port: listenPort, Transports: transports}}
+	}
+
+	// dns over tls is added to the other listeners on the same hosts
+	if *dot {
+		listenPort, err := strconv.Atoi(*dotPort)
+		if err != nil || listenPort < 1 || listenPort > 65535 {
+			return fmt.Errorf("the port \"%s\" is not a valid dns over tls port", *dotPort)
+		}
+		cfg.Listeners = append(cfg.Listeners, ListenerConfig{Host: *hosts, Port: listenPort, Transports: []string{"dot"}})
+	}
+
+	// and so is dns over https
+	if *doh {
+		listenPort, err := strconv.Atoi(*dohPort)
+		if err != nil || listenPort < 1 || listenPort > 65535 {
+			return fmt.Errorf("the port \"%s\" is not a valid dns over https port", *dohPort)
+		}
+		cfg.Listeners = append(cfg.Listeners, ListenerConfig{Host: *hosts, Port: listenPort, Transports: []string{"doh"}})
+	}
+
+	// the number of sockets applies to every listener
+	if setFlags["sockets"] {
+		if *sockets < 1 {
+			return fmt.Errorf("the number of sockets %d is not valid", *sockets)
+		}
+		for idx := range cfg.Listeners {
+			cfg.Listeners[idx].Sockets = *sockets
+		}
+	}
+	if setFlags["tlsCert"] {
+		cfg.TLS.Cert = *tlsCert
+	}
+	if setFlags["tlsKey"] {
+		cfg.TLS.Key = *tlsKey
+	}
+	if setFlags["tlsSelfSigned"] {
+		cfg.TLS.SelfSigned = *tlsSelfSigned
+	}
+
+	if setFlags["compress"] {
+		cfg.Compress = *compress
+	}
+
+	if setFlags["answers"] {
+		cfg.Answers.Preset = *answers
+	}
+
+	if setFlags["rateLimit"] {
+		cfg.RateLimit.ResponsesPerSecond = *rateLimitResponses
+	}
+	if setFlags["rateLimitWindow"] {
+		cfg.RateLimit.Window = *rateLimitWindow
+	}
+	if setFlags["rateLimitSlip"] {
+		cfg.RateLimit.Slip = *rateLimitSlip
+	}
+
+	if setFlags["ednsBufferSize"] {
+		if *bufferSize < 0 || *bufferSize > 65535 {
+			return fmt.Errorf("the edns buffer size %d is not valid", *bufferSize)
+		}
+		cfg.EDNS.BufferSize = uint16(*bufferSize)
+	}
+
+	if setFlags["logQueries"] {
+		cfg.Logging.Queries = *logQueries
+	}
+	if setFlags["querySample"] {
+		cfg.Logging.QuerySample = *querySampleRate
+	}
+	if setFlags["queryLog"] {
+		cfg.Logging.QueryFile.Path = *queryLogPath
+	}
+	if setFlags["queryLogMaxSize"] {
+		cfg.Logging.QueryFile.MaxSize = *queryLogMaxSize
+	}
+	if setFlags["queryLogMaxAge"] {
+		cfg.Logging.QueryFile.MaxAge = *queryLogMaxAge
+	}
+	if setFlags["queryLogCompress"] {
+		cfg.Logging.QueryFile.Compress = *queryLogCompress
+	}
+	if setFlags["queryLogKeep"] {
+		cfg.Logging.QueryFile.Keep = *queryLogKeep
+	}
+	if setFlags["logLevel"] {
+		cfg.Logging.Level = *logLevel
+	}
+	if setFlags["logFormat"] {
+		cfg.Logging.Format = *logFormat
+	}
+
+	if setFlags["admin"] {
+		cfg.Admin.Address = *adminAddress
+	}
+	if setFlags["adminToken"] {
+		cfg.Admin.Token = *adminToken
+	}
+	if setFlags["recordsFile"] {
+		cfg.Records.File = *recordsFile
+	}
+	if setFlags["recordsImport"] {
+		cfg.Records.Import = *recordsImport
+	}
+	if setFlags["dnstapSocket"] {
+		cfg.Dnstap.Socket = *dnstapSocket
+	}
+	if setFlags["dnstapFile"] {
+		cfg.Dnstap.File = *dnstapFile
+	}
+	if setFlags["dnstapIdentity"] {
+		cfg.Dnstap.Identity = *dnstapIdentity
+	}
+	if setFlags["chaosOff"] {
+		cfg.Chaos.Off = *chaosOff
+	}
+	if setFlags["allowPartial"] {
+		cfg.AllowPartial = *allowPartial
+	}
+	if setFlags["shutdownGrace"] {
+		cfg.Shutdown.GracePeriod = *shutdownGrace
+	}
+
+	if setFlags["allowClients"] {
+		cfg.Clients.Allow = splitList(*allowClients)
+	}
+	if setFlags["denyClients"] {
+		cfg.Clients.Deny = splitList(*denyClients)
+	}
+	if setFlags["dropDenied"] {
+		cfg.Clients.Denied = clientRefuse
+		if *dropDenied {
+			cfg.Clients.Denied = clientDrop
+		}
+	}
+
+	return nil
+}
+
+// fills in defaults for anything the configuration file left empty and makes sure that
+// the result is something that can be served
+func finishConfig(cfg *Config) error {
+	if len(cfg.Domains) < 1 {
+		return fmt.Errorf("at least one domain to host is required")
+	}
+	// values from the command line haven't been checked yet
+	if errs := validateConfig(cfg); len(errs) > 0 {
+		return fmt.Errorf("%s", errs[0].message)
+	}
+	for idx := range cfg.Domains {
+		domainConfig := &cfg.Domains[idx]
+		domainConfig.Name = dnsName(domainConfig.Name)
+		answerList, err := buildAnswerList(cfg.Answers, domainConfig.Answers)
+		if err != nil {
+			return fmt.Errorf("the answers for domain %s are not valid: %s", domainConfig.Name, err)
+		}
+		domainConfig.answers = answerList
+		if err := domainConfig.Clients.build(); err != nil {
+			return fmt.Errorf("the clients for domain %s are not valid: %s", domainConfig.Name, err)
+		}
+	}
+
+	if cfg.EDNS.BufferSize == 0 {
+		cfg.EDNS.BufferSize = defaultEDNSBufferSize
+	}
+	if cfg.Logging.Level == "" {
+		cfg.Logging.Level = "info"
+	}
+	if cfg.Logging.Format == "" {
+		cfg.Logging.Format = "text"
+	}
+
+	if err := cfg.Clients.build(); err != nil {
+		return fmt.Errorf("the clients are not valid: %s", err)
+	}
+
+	for idx := range cfg.Listeners {
+		listenerConfig := &cfg.Listeners[idx]
+		if listenerConfig.Host == "" {
+			listenerConfig.Host = "0.0.0.0"
+		}
+		if listenerConfig.Transports == nil {
+			listenerConfig.Transports = append([]string{}, defaultTransports...)
+		}
+		if listenerConfig.Port == 0 && len(listenerConfig.Transports) == 1 {
+			switch strings.ToLower(listenerConfig.Transports[0]) {
+			case "dot":
+				listenerConfig.Port = defaultDoTPort
+			case "doh":
+				listenerConfig.Port = defaultDoHPort
+			}
+		}
+		if listenerConfig.Port == 0 {
+			listenerConfig.Port, _ = strconv.Atoi(flag.Lookup("port").DefValue)
+		}
+		if listenerConfig.Sockets == 0 {
+			listenerConfig.Sockets = 1
+		}
+		for domainIdx := range listenerConfig.Domains {
+			listenerConfig.Domains[domainIdx] = dnsName(listenerConfig.Domains[domainIdx])
+		}
+		if len(listenerConfig.Transports) < 1 {
+			return fmt.Errorf("the listener on %s:%d does not have any transports", listenerConfig.Host, listenerConfig.Port)
+		}
+		if err := listenerConfig.Clients.build(); err != nil {
+			return fmt.Errorf("the clients for the listener on %s:%d are not valid: %s", listenerConfig.Host, listenerConfig.Port, err)
+		}
+	}
+
+	return nil
+}
+
+// lowercases the domain and gives it the trailing '.' that dns names use
+func dnsName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "" && !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	return name
+}
+
+// returns true if the name is one of the domains
+func servesDomain(domains []DomainConfig, name string) bool {
+	for _, domainConfig := range domains {
+		if dnsName(domainConfig.Name) == dnsName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// the listener definitions given on the command line, each flag can have more than one separated by spaces
+type listenFlag []string
+
+func (specs *listenFlag) String() string {
+	return strings.Join(*specs, " ")
+}
+
+func (specs *listenFlag) Set(value string) error {
+	*specs = append(*specs, strings.Fields(value)...)
+	return nil
+}
+
+// parses a listener definition: the transports joined with "+", the address, an optional port, and an
+// optional comma-separated list of the domains served (Ex: "udp+tcp://10.0.0.1:53/gyip.io,gyip.net")
+func parseListener(spec string) (ListenerConfig, error) {
+	listenerConfig := ListenerConfig{}
+	parsed, err := url.Parse(spec)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return listenerConfig, fmt.Errorf("the listener \"%s\" is not of the form transport[+transport]://host[:port][/domain[,domain]]", spec)
+	}
+
+	for _, transport := range strings.Split(parsed.Scheme, "+") {
+		if !containsString(validTransports, transport) {
+			return listenerConfig, fmt.Errorf("the listener \"%s\" has the transport \"%s\" which is not one of %v", spec, transport, validTransports)
+		}
+		listenerConfig.Transports = append(listenerConfig.Transports, transport)
+	}
+
+	listenerConfig.Host = parsed.Hostname()
+	if parsed.Port() != "" {
+		listenerConfig.Port, err = strconv.Atoi(parsed.Port())
+		if err != nil || listenerConfig.Port < 1 || listenerConfig.Port > 65535 {
+			return listenerConfig, fmt.Errorf("the listener \"%s\" does not have a valid port", spec)
+		}
+	}
+
+	listenerConfig.Domains = splitList(strings.Trim(parsed.Path, "/"))
+	if len(listenerConfig.Domains) < 1 {
+		listenerConfig.Domains = nil
+	}
+
+	return listenerConfig, nil
+}
+
+// splits a comma-separated list and leaves out empty entries
+func splitList(input string) []string {
+	values := []string{}
+	for _, value := range strings.Split(input, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func containsString(values []string, value string) bool {
+	for _, check := range values {
+		if check == value {
+			return true
+		}
+	}
+	return false
+}
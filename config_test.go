@@ -0,0 +1,297 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// writes the contents to a temporary file with the given name and returns the path
+func writeTempConfig(t *testing.T, name string, contents string) string {
+	dir, err := ioutil.TempDir("", "gyip-config")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Could not write temporary config: %s", err)
+	}
+	return path
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	data := []struct {
+		name     string
+		contents string
+	}{
+		{"config.yaml", `
+domains:
+  - name: gyip.io
+    ttl: 300
+    commands: [rr]
+listeners:
+  - host: 127.0.0.1
+    port: 5353
+    transports: [udp]
+compress: true
+logging:
+  queries: false
+`},
+		{"config.toml", `
+compress = true
+
+[[domains]]
+name = "gyip.io"
+ttl = 300
+commands = ["rr"]
+
+[[listeners]]
+host = "127.0.0.1"
+port = 5353
+transports = ["udp"]
+
+[logging]
+queries = false
+`},
+		{"config.json", `{
+  "domains": [{"name": "gyip.io", "ttl": 300, "commands": ["rr"]}],
+  "listeners": [{"host": "127.0.0.1", "port": 5353, "transports": ["udp"]}],
+  "compress": true,
+  "logging": {"queries": false}
+}`},
+	}
+
+	for _, item := range data {
+		path := writeTempConfig(t, item.name, item.contents)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Errorf("The config '%s' could not be loaded: %s", item.name, err)
+			continue
+		}
+		if len(cfg.Domains) != 1 || cfg.Domains[0].Name != "gyip.io" || cfg.Domains[0].TTL != 300 || len(cfg.Domains[0].Commands) != 1 {
+			t.Errorf("The config '%s' did not load the expected domains (was: %v)", item.name, cfg.Domains)
+		}
+		if len(cfg.Listeners) != 1 || cfg.Listeners[0].Host != "127.0.0.1" || cfg.Listeners[0].Port != 5353 || len(cfg.Listeners[0].Transports) != 1 {
+			t.Errorf("The config '%s' did not load the expected listeners (was: %v)", item.name, cfg.Listeners)
+		}
+		if !cfg.Compress || cfg.Logging.Queries {
+			t.Errorf("The config '%s' did not load the expected options (compress: %t, queries: %t)", item.name, cfg.Compress, cfg.Logging.Queries)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	data := []struct {
+		name     string
+		contents string
+		expected []string
+	}{
+		{"bad.yaml", "domains:\n  - name: gyip.io\n    tll: 300\n", []string{"bad.yaml:3:"}},
+		{"bad.yaml", "domains:\n  - name: gyip.io\n  - name: fort@gyip.io\n    commands: [rr, boom]\n", []string{"bad.yaml:3: domains[1].name", "bad.yaml:4: domains[1].commands"}},
+		{"bad.yaml", "domains:\n  - name: gyip.io\n    keywords: [echo, mirror]\n    encodings: [ipv5]\n    disallowed: explode\n", []string{"bad.yaml:3: domains[0].keywords", "bad.yaml:4: domains[0].encodings", "bad.yaml:5: domains[0].disallowed"}},
+		{"bad.yaml", "answers:\n  preset: nope\ndomains:\n  - name: gyip.io\n    answers:\n      allow: [10.0.0.0/8, 10.0.0.0/99]\n", []string{"bad.yaml:2: answers.preset", "bad.yaml:6: domains[0].answers"}},
+		{"bad.yaml", "clients:\n  denied: ignore\nlisteners:\n  - clients:\n      allow: [lab]\n", []string{"bad.yaml:2: clients.denied", "bad.yaml:5: listeners[0].clients"}},
+		{"bad.yaml", "rateLimit:\n  responsesPerSecond: 5\n  window: 0\n  ipv4Prefix: 40\n", []string{"bad.yaml:3: rateLimit.window", "bad.yaml:4: rateLimit.ipv4Prefix"}},
+		{"bad.yaml", "listeners:\n  - port: 70000\n    transports: [udp, carrier-pigeon]\n", []string{"bad.yaml:2: listeners[0].port", "bad.yaml:3: listeners[0].transports"}},
+		{"bad.yaml", "logging:\n  level: loud\n  format: xml\n  querySample: -1\n  queryFile:\n    keep: -2\n", []string{"bad.yaml:2: logging.level", "bad.yaml:3: logging.format", "bad.yaml:4: logging.querySample", "bad.yaml:6: logging.queryFile.keep"}},
+		{"bad.yaml", "admin:\n  address: localhost\n", []string{"bad.yaml:2: admin.address"}},
+		{"bad.yaml", "admin:\n  token: secret\n", []string{"bad.yaml:2: admin.token"}},
+		{"bad.yaml", "dnstap:\n  socket: /run/dnstap.sock\n  file: /var/log/gyip.dnstap\n", []string{"bad.yaml:3: dnstap"}},
+		// lines are only given for values that can't be mistaken for another part of the file
+		{"bad.yaml", "domains:\n  - name: a.io\n  - name: b.io\n  - ttl: 5\n", []string{"bad.yaml: domains[2].name"}},
+		{"bad.yaml", "listeners:\n  - host: 10.0.0.1\n    port: 70000\n  - host: 10.0.0.2\n    port: 70000\n", []string{"bad.yaml: listeners[0].port", "bad.yaml: listeners[1].port"}},
+		{"bad.yaml", "domains:\n  - name: mirror.io\n    keywords: [mirror]\n", []string{"bad.yaml:3: domains[0].keywords"}},
+		{"bad.toml", "[[domains]]\nname = \"gyip.io\"\nttl = \"long\"\n", []string{"bad.toml:"}},
+		{"bad.toml", "[[domains]]\nname = \"gyip.io\"\n\n[extra]\nvalue = 1\n", []string{"bad.toml:4: unknown field \"extra\""}},
+		{"bad.json", "{\n  \"domains\": [\n    {\"name\": \"gyip.io\", \"ttl\": \"long\"}\n  ]\n}", []string{"bad.json:3:"}},
+		{"bad.json", "{\n  \"domains\": [],\n  \"extra\": 1\n}", []string{"bad.json:3: unknown field \"extra\""}},
+		{"bad.json", "{\n  \"domains\": [\n", []string{"bad.json:"}},
+		{"bad.ini", "domain=gyip.io", []string{"must end in"}},
+	}
+
+	for _, item := range data {
+		path := writeTempConfig(t, item.name, item.contents)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := loadConfig(path)
+		if err == nil {
+			t.Errorf("The config '%s' should not have loaded", item.contents)
+			continue
+		}
+		for _, expected := range item.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("The error for config '%s' did not contain '%s' (was: %s)", item.contents, expected, err)
+			}
+		}
+	}
+}
+
+func TestFinishConfig(t *testing.T) {
+	cfg := &Config{
+		Domains:   []DomainConfig{{Name: "GYIP.io"}},
+		Listeners: []ListenerConfig{{}},
+	}
+	if err := finishConfig(cfg); err != nil {
+		t.Fatalf("The config could not be finished: %s", err)
+	}
+	if cfg.Domains[0].Name != "gyip.io." {
+		t.Errorf("The domain name was not normalized (was: %s)", cfg.Domains[0].Name)
+	}
+	if cfg.Listeners[0].Host != "0.0.0.0" || cfg.Listeners[0].Port != 8053 || len(cfg.Listeners[0].Transports) != 2 {
+		t.Errorf("The listener did not get default values (was: %v)", cfg.Listeners[0])
+	}
+
+	if err := finishConfig(&Config{}); err == nil {
+		t.Errorf("A config without domains should not be accepted")
+	}
+	if err := finishConfig(&Config{Domains: []DomainConfig{{Name: "gyip.io"}}, Listeners: []ListenerConfig{{Transports: []string{}}}}); err == nil {
+		t.Errorf("A listener without transports should not be accepted")
+	}
+}
+
+func TestDomainOptions(t *testing.T) {
+	servingDomains = []*DomainConfig{
+		{Name: "short.io.", TTL: 60},
+		{Name: "nocommands.io.", Commands: []string{}},
+		{Name: "rronly.io.", Commands: []string{"rr"}},
+	}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	data := []struct {
+		questionDomain string
+		inputQuestion  string
+		expectedCount  int
+		expectedTTL    uint32
+	}{
+		{"short.io", "10.0.0.1.short.io", 1, 60},
+		{"short.io", "10.0.0.1.10.0.0.2.rr.short.io", 1, 10},
+		{"nocommands.io", "10.0.0.1.10.0.0.2.rr.nocommands.io", 2, 43200},
+		{"rronly.io", "10.0.0.1.10.0.0.2.rr.rronly.io", 1, 10},
+		{"rronly.io", "10.0.0.1.10.0.0.2.f99.rronly.io", 2, 43200},
+	}
+
+	for _, item := range data {
+		records, _ := frameResponse(nil, dns.TypeA, item.inputQuestion, item.questionDomain)
+		if len(records) != item.expectedCount {
+			t.Errorf("The query '%s' did not return the expected number of records (returned %d, expected %d)", item.inputQuestion, len(records), item.expectedCount)
+			continue
+		}
+		for _, record := range records {
+			if record.Header().Ttl != item.expectedTTL {
+				t.Errorf("The query '%s' did not return the expected ttl (was %d, expected %d)", item.inputQuestion, record.Header().Ttl, item.expectedTTL)
+			}
+		}
+	}
+}
+
+func TestEnvironmentName(t *testing.T) {
+	data := []struct {
+		flagName string
+		expected string
+	}{
+		{"domain", "GYIP_DOMAIN"},
+		{"tcpOff", "GYIP_TCP_OFF"},
+		{"udpOff", "GYIP_UDP_OFF"},
+		{"config", "GYIP_CONFIG"},
+	}
+
+	for _, item := range data {
+		if name := environmentName(item.flagName); name != item.expected {
+			t.Errorf("The flag '%s' did not produce the expected environment variable (was: %s, expected %s)", item.flagName, name, item.expected)
+		}
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	testDomain := flags.String("domain", "", "")
+	testPort := flags.String("port", "8053", "")
+	testTCPOff := flags.Bool("tcpOff", false, "")
+
+	os.Setenv("GYIP_DOMAIN", "env.gyip.io")
+	os.Setenv("GYIP_PORT", "9053")
+	os.Setenv("GYIP_TCP_OFF", "true")
+	defer os.Unsetenv("GYIP_DOMAIN")
+	defer os.Unsetenv("GYIP_PORT")
+	defer os.Unsetenv("GYIP_TCP_OFF")
+
+	// the command line wins over the environment
+	if err := flags.Parse([]string{"--port", "10053"}); err != nil {
+		t.Fatalf("Could not parse flags: %s", err)
+	}
+	if err := applyEnvironment(flags); err != nil {
+		t.Fatalf("Could not apply environment: %s", err)
+	}
+
+	if *testDomain != "env.gyip.io" || *testPort != "10053" || !*testTCPOff {
+		t.Errorf("The environment was not applied as expected (domain: %s, port: %s, tcpOff: %t)", *testDomain, *testPort, *testTCPOff)
+	}
+
+	// bad values are reported
+	os.Setenv("GYIP_TCP_OFF", "maybe")
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Bool("tcpOff", false, "")
+	if err := applyEnvironment(flags); err == nil || !strings.Contains(err.Error(), "GYIP_TCP_OFF") {
+		t.Errorf("An invalid environment value was not reported (was: %v)", err)
+	}
+}
+
+func TestParseListener(t *testing.T) {
+	data := []struct {
+		spec       string
+		host       string
+		port       int
+		transports []string
+		domains    []string
+	}{
+		{"udp+tcp://10.0.0.1:53", "10.0.0.1", 53, []string{"udp", "tcp"}, nil},
+		{"udp://127.0.0.1:8053/gyip.io", "127.0.0.1", 8053, []string{"udp"}, []string{"gyip.io"}},
+		{"dot://0.0.0.0", "0.0.0.0", 0, []string{"dot"}, nil},
+		{"DOH://[::1]:8443/gyip.io,gyip.net/", "::1", 8443, []string{"doh"}, []string{"gyip.io", "gyip.net"}},
+	}
+
+	for _, item := range data {
+		listenerConfig, err := parseListener(item.spec)
+		if err != nil {
+			t.Errorf("The listener '%s' could not be parsed: %s", item.spec, err)
+			continue
+		}
+		if listenerConfig.Host != item.host || listenerConfig.Port != item.port || !reflect.DeepEqual(listenerConfig.Transports, item.transports) || !reflect.DeepEqual(listenerConfig.Domains, item.domains) {
+			t.Errorf("The listener '%s' was not parsed as expected (was: %v)", item.spec, listenerConfig)
+		}
+	}
+
+	for _, spec := range []string{"10.0.0.1:53", "udp://", "sctp://10.0.0.1:53", "udp://10.0.0.1:99999", "udp://10.0.0.1:53?domains=gyip.io"} {
+		if _, err := parseListener(spec); err == nil {
+			t.Errorf("The listener '%s' should not have been parsed", spec)
+		}
+	}
+}
+
+func TestListenerDomains(t *testing.T) {
+	cfg := &Config{
+		Domains:   []DomainConfig{{Name: "gyip.io"}, {Name: "gyip.net"}},
+		Listeners: []ListenerConfig{{Domains: []string{"GYIP.io"}}},
+	}
+	if err := finishConfig(cfg); err != nil {
+		t.Fatalf("The config could not be finished: %s", err)
+	}
+	if !reflect.DeepEqual(cfg.Listeners[0].Domains, []string{"gyip.io."}) {
+		t.Errorf("The listener domains were not normalized (was: %v)", cfg.Listeners[0].Domains)
+	}
+
+	cfg.Listeners[0].Domains = []string{"gyip.com"}
+	if err := finishConfig(cfg); err == nil {
+		t.Errorf("A listener for a domain that isn't served should not be accepted")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/chrisruffalo/gyip/dnstap"
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/miekg/dns"
+)
+
+// where dnstap messages are written, nil when they are not
+var dnstapOutput *dnstap.Output
+
+// DnstapConfig - writes every question and response as a dnstap message
+type DnstapConfig struct {
+	// the unix socket of a dnstap collector, the connection is made again whenever it is lost
+	Socket string `json:"socket" yaml:"socket" toml:"socket"`
+	// the file that the messages are written to instead of a socket, it is replaced when the server starts
+	File string `json:"file" yaml:"file" toml:"file"`
+	// the name of this server in each message, defaults to the host name
+	Identity string `json:"identity" yaml:"identity" toml:"identity"`
+}
+
+// the dnstap socket protocol of each transport
+var dnstapProtocols = map[string]dnstap.SocketProtocol{
+	"udp": dnstap.UDP,
+	"tcp": dnstap.TCP,
+	"dot": dnstap.DOT,
+	"doh": dnstap.DOH,
+}
+
+// starts writing dnstap messages to the socket or file (if one is given)
+func configureDnstap(dnstapConfig DnstapConfig) error {
+	identity := dnstapConfig.Identity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	version := "gyip " + LongVersion
+	// errors writing the messages don't stop the server
+	report := func(err error) {
+		logger.Warn("The dnstap messages could not be written", logging.F("error", err))
+	}
+
+	if dnstapConfig.Socket != "" {
+		dnstapOutput = dnstap.NewSocketOutput(dnstapConfig.Socket, identity, version, report)
+	} else if dnstapConfig.File != "" {
+		output, err := dnstap.NewFileOutput(dnstapConfig.File, identity, version, report)
+		if err != nil {
+			return fmt.Errorf("the dnstap file could not be opened: %s", err)
+		}
+		dnstapOutput = output
+	}
+	return nil
+}
+
+// writes the dnstap messages that are waiting and ends the stream
+func closeDnstap() {
+	if dnstapOutput == nil {
+		return
+	}
+	if err := dnstapOutput.Close(); err != nil {
+		logger.Error("The dnstap output could not be closed", logging.F("error", err))
+	}
+	if dropped := dnstapOutput.Dropped(); dropped > 0 {
+		logger.Warn("Some dnstap messages were dropped because they could not be written fast enough", logging.F("dropped", dropped))
+	}
+}
+
+// wraps the handler so that every question and the response it was given are written as dnstap messages,
+// questions that were not answered only have the query message
+func tap(next dns.Handler, transport string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if dnstapOutput == nil || isReadinessQuestion(r) {
+			next.ServeDNS(w, r)
+			return
+		}
+
+		message := &dnstap.Message{Protocol: dnstapProtocols[transport], QueryTime: time.Now()}
+		message.QueryAddress, message.QueryPort = addrParts(w.RemoteAddr())
+		message.ResponseAddress, message.ResponsePort = addrParts(w.LocalAddr())
+		if len(r.Question) > 0 {
+			message.QueryZone = packedName(domainOf(dns.Fqdn(r.Question[0].Name)))
+		}
+		// the question is packed before the handlers can change it
+		message.QueryMessage, _ = r.Pack()
+		query := *message
+		query.Type = dnstap.AUTH_QUERY
+		dnstapOutput.Send(&query)
+
+		writer := &queryWriter{ResponseWriter: w}
+		next.ServeDNS(writer, r)
+		if writer.written == nil {
+			return
+		}
+		message.Type = dnstap.AUTH_RESPONSE
+		message.ResponseTime = time.Now()
+		message.ResponseMessage, _ = writer.written.Pack()
+		dnstapOutput.Send(message)
+	})
+}
+
+// the ip address and port of a socket address, nil and 0 when they aren't known
+func addrParts(addr net.Addr) (net.IP, int) {
+	switch socketAddr := addr.(type) {
+	case *net.UDPAddr:
+		return socketAddr.IP, socketAddr.Port
+	case *net.TCPAddr:
+		return socketAddr.IP, socketAddr.Port
+	}
+	return nil, 0
+}
+
+// the wire format of the name, nil when there isn't one
+func packedName(name string) []byte {
+	if name == "" {
+		return nil
+	}
+	packed := make([]byte, 255)
+	length, err := dns.PackDomainName(name, packed, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	return packed[:length]
+}
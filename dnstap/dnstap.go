@@ -0,0 +1,195 @@
+package dnstap
+
+import (
+	"net"
+	"time"
+)
+
+// MessageType - the kind of dns message that was seen (dnstap.Message.Type)
+type MessageType int
+
+const (
+	AUTH_QUERY    MessageType = 1
+	AUTH_RESPONSE MessageType = 2
+)
+
+// SocketProtocol - the transport the message was sent over (dnstap.SocketProtocol)
+type SocketProtocol int
+
+const (
+	UDP SocketProtocol = 1
+	TCP SocketProtocol = 2
+	DOT SocketProtocol = 3
+	DOH SocketProtocol = 4
+)
+
+// the socket families (dnstap.SocketFamily)
+const (
+	familyINET  = 1
+	familyINET6 = 2
+)
+
+// the type of the outer dnstap message, the only one there is
+const dnstapMessage = 1
+
+// the fields of the dnstap.Dnstap message
+const (
+	fieldIdentity = 1
+	fieldVersion  = 2
+	fieldMessage  = 14
+	fieldType     = 15
+)
+
+// the fields of the dnstap.Message message
+const (
+	fieldMessageType      = 1
+	fieldSocketFamily     = 2
+	fieldSocketProtocol   = 3
+	fieldQueryAddress     = 4
+	fieldResponseAddress  = 5
+	fieldQueryPort        = 6
+	fieldResponsePort     = 7
+	fieldQueryTimeSec     = 8
+	fieldQueryTimeNsec    = 9
+	fieldQueryMessage     = 10
+	fieldQueryZone        = 11
+	fieldResponseTimeSec  = 12
+	fieldResponseTimeNsec = 13
+	fieldResponseMessage  = 14
+)
+
+// Message - a dns message that was seen along with where and when, the query address is the client
+// and the response address is the server whichever way the message went
+type Message struct {
+	Type            MessageType
+	Protocol        SocketProtocol
+	QueryAddress    net.IP
+	QueryPort       int
+	ResponseAddress net.IP
+	ResponsePort    int
+	// the zone the question is in, in wire format
+	QueryZone []byte
+	// the question in wire format and when it was received
+	QueryMessage []byte
+	QueryTime    time.Time
+	// the response in wire format and when it was sent, only for responses
+	ResponseMessage []byte
+	ResponseTime    time.Time
+}
+
+// Marshal - the protobuf encoding of a dnstap.Dnstap message holding the message
+func (m *Message) Marshal(identity []byte, version []byte) []byte {
+	inner := &buffer{}
+	inner.varintField(fieldMessageType, uint64(m.Type))
+	family, queryAddress, responseAddress := addresses(m.QueryAddress, m.ResponseAddress)
+	if family != 0 {
+		inner.varintField(fieldSocketFamily, uint64(family))
+	}
+	if m.Protocol != 0 {
+		inner.varintField(fieldSocketProtocol, uint64(m.Protocol))
+	}
+	if queryAddress != nil {
+		inner.bytesField(fieldQueryAddress, queryAddress)
+	}
+	if responseAddress != nil {
+		inner.bytesField(fieldResponseAddress, responseAddress)
+	}
+	if m.QueryPort > 0 {
+		inner.varintField(fieldQueryPort, uint64(m.QueryPort))
+	}
+	if m.ResponsePort > 0 {
+		inner.varintField(fieldResponsePort, uint64(m.ResponsePort))
+	}
+	if !m.QueryTime.IsZero() {
+		inner.varintField(fieldQueryTimeSec, uint64(m.QueryTime.Unix()))
+		inner.fixed32Field(fieldQueryTimeNsec, uint32(m.QueryTime.Nanosecond()))
+	}
+	if m.QueryMessage != nil {
+		inner.bytesField(fieldQueryMessage, m.QueryMessage)
+	}
+	if m.QueryZone != nil {
+		inner.bytesField(fieldQueryZone, m.QueryZone)
+	}
+	if !m.ResponseTime.IsZero() {
+		inner.varintField(fieldResponseTimeSec, uint64(m.ResponseTime.Unix()))
+		inner.fixed32Field(fieldResponseTimeNsec, uint32(m.ResponseTime.Nanosecond()))
+	}
+	if m.ResponseMessage != nil {
+		inner.bytesField(fieldResponseMessage, m.ResponseMessage)
+	}
+
+	outer := &buffer{}
+	if identity != nil {
+		outer.bytesField(fieldIdentity, identity)
+	}
+	if version != nil {
+		outer.bytesField(fieldVersion, version)
+	}
+	outer.bytesField(fieldMessage, inner.bytes)
+	outer.varintField(fieldType, dnstapMessage)
+	return outer.bytes
+}
+
+// the socket family and the addresses in the form for that family, the addresses are left out
+// (and the family is 0) when neither is known
+func addresses(query net.IP, response net.IP) (int, []byte, []byte) {
+	known := query
+	if known == nil {
+		known = response
+	}
+	if known == nil {
+		return 0, nil, nil
+	}
+	if known.To4() != nil {
+		return familyINET, to4(query), to4(response)
+	}
+	return familyINET6, query.To16(), response.To16()
+}
+
+// the four byte form of an ipv4 address, nil for anything else
+func to4(ip net.IP) []byte {
+	if ip == nil {
+		return nil
+	}
+	return ip.To4()
+}
+
+// the protobuf wire types that are used
+const (
+	wireVarint  = 0
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// a protobuf message being encoded
+type buffer struct {
+	bytes []byte
+}
+
+func (b *buffer) varint(value uint64) {
+	for value >= 0x80 {
+		b.bytes = append(b.bytes, byte(value)|0x80)
+		value >>= 7
+	}
+	b.bytes = append(b.bytes, byte(value))
+}
+
+func (b *buffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *buffer) varintField(field int, value uint64) {
+	b.key(field, wireVarint)
+	b.varint(value)
+}
+
+func (b *buffer) bytesField(field int, value []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(value)))
+	b.bytes = append(b.bytes, value...)
+}
+
+func (b *buffer) fixed32Field(field int, value uint32) {
+	b.key(field, wireFixed32)
+	b.bytes = append(b.bytes, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
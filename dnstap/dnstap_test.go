@@ -0,0 +1,245 @@
+package dnstap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the fields of a protobuf message by number, only the last value of each field is kept
+type decoded map[int][]byte
+
+// decodes a protobuf message, varints and fixed32 values are kept as their encoded bytes
+func decode(t *testing.T, data []byte) decoded {
+	fields := decoded{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		field := int(key >> 3)
+		switch key & 0x07 {
+		case wireVarint:
+			_, n = binary.Uvarint(data)
+			fields[field] = data[:n]
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			fields[field] = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case wireFixed32:
+			fields[field] = data[:4]
+			data = data[4:]
+		default:
+			t.Fatalf("Unexpected wire type %d", key&0x07)
+		}
+	}
+	return fields
+}
+
+func (fields decoded) varint(field int) uint64 {
+	value, _ := binary.Uvarint(fields[field])
+	return value
+}
+
+func TestMarshal(t *testing.T) {
+	queryTime := time.Unix(1500000000, 123456789)
+	data := []struct {
+		message  Message
+		family   uint64
+		query    []byte
+		response []byte
+	}{
+		{Message{Type: AUTH_QUERY, Protocol: UDP, QueryAddress: net.ParseIP("10.0.0.1"), QueryPort: 5353, ResponseAddress: net.ParseIP("10.0.0.2"), ResponsePort: 53, QueryMessage: []byte{1, 2, 3}, QueryTime: queryTime}, familyINET, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}},
+		{Message{Type: AUTH_RESPONSE, Protocol: TCP, QueryAddress: net.ParseIP("fe80::1"), QueryPort: 5353, QueryMessage: []byte{1, 2, 3}, ResponseMessage: []byte{4, 5}, QueryTime: queryTime, ResponseTime: queryTime}, familyINET6, net.ParseIP("fe80::1"), nil},
+		{Message{Type: AUTH_QUERY, Protocol: DOH}, 0, nil, nil},
+	}
+
+	for _, item := range data {
+		outer := decode(t, item.message.Marshal([]byte("host"), []byte("gyip")))
+		if string(outer[fieldIdentity]) != "host" || string(outer[fieldVersion]) != "gyip" || outer.varint(fieldType) != dnstapMessage {
+			t.Errorf("The dnstap message for %v did not have the identity, version and type", item.message)
+			continue
+		}
+		inner := decode(t, outer[fieldMessage])
+		if inner.varint(fieldMessageType) != uint64(item.message.Type) || inner.varint(fieldSocketProtocol) != uint64(item.message.Protocol) {
+			t.Errorf("The message for %v did not have the type and protocol", item.message)
+		}
+		if inner.varint(fieldSocketFamily) != item.family {
+			t.Errorf("The message for %v had family %d and not %d", item.message, inner.varint(fieldSocketFamily), item.family)
+		}
+		if !bytes.Equal(inner[fieldQueryAddress], item.query) || !bytes.Equal(inner[fieldResponseAddress], item.response) {
+			t.Errorf("The message for %v had the addresses %v and %v", item.message, inner[fieldQueryAddress], inner[fieldResponseAddress])
+		}
+		if inner.varint(fieldQueryPort) != uint64(item.message.QueryPort) || inner.varint(fieldResponsePort) != uint64(item.message.ResponsePort) {
+			t.Errorf("The message for %v did not have the ports", item.message)
+		}
+		if !bytes.Equal(inner[fieldQueryMessage], item.message.QueryMessage) || !bytes.Equal(inner[fieldResponseMessage], item.message.ResponseMessage) {
+			t.Errorf("The message for %v did not have the dns messages", item.message)
+		}
+		if !item.message.QueryTime.IsZero() && (inner.varint(fieldQueryTimeSec) != 1500000000 || binary.LittleEndian.Uint32(inner[fieldQueryTimeNsec]) != 123456789) {
+			t.Errorf("The message for %v did not have the query time", item.message)
+		}
+		if _, found := inner[fieldResponseTimeSec]; found == item.message.ResponseTime.IsZero() {
+			t.Errorf("The message for %v should only have a response time if it was given one", item.message)
+		}
+	}
+}
+
+// reads the data frames of a frame stream until the STOP control frame
+func readFrames(t *testing.T, r io.Reader) [][]byte {
+	frames := [][]byte{}
+	for {
+		length := make([]byte, 4)
+		if _, err := io.ReadFull(r, length); err != nil {
+			t.Fatalf("Could not read a frame: %s", err)
+		}
+		if binary.BigEndian.Uint32(length) == 0 {
+			// a control frame, put the escape back so that it can be read as one
+			control := io.MultiReader(bytes.NewReader(length), r)
+			if _, err := readControl(control, controlStop); err != nil {
+				t.Fatalf("Expected the STOP control frame: %s", err)
+			}
+			return frames
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(length))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			t.Fatalf("Could not read a frame: %s", err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnstap")
+	if err != nil {
+		t.Fatalf("Could not create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gyip.dnstap")
+
+	output, err := NewFileOutput(path, "host", "gyip", func(err error) { t.Errorf("Unexpected error: %s", err) })
+	if err != nil {
+		t.Fatalf("Could not create the output: %s", err)
+	}
+	for idx := 0; idx < 3; idx++ {
+		output.Send(&Message{Type: AUTH_QUERY, Protocol: UDP, QueryPort: idx + 1})
+	}
+	if err := output.Close(); err != nil {
+		t.Fatalf("Could not close the output: %s", err)
+	}
+	// nothing is sent once the output is closed
+	output.Send(&Message{Type: AUTH_QUERY})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Could not open the stream: %s", err)
+	}
+	defer file.Close()
+	contentTypes, err := readControl(file, controlStart)
+	if err != nil || len(contentTypes) != 1 || contentTypes[0] != ContentType {
+		t.Fatalf("The stream did not start with the content type: %v %s", contentTypes, err)
+	}
+	frames := readFrames(t, file)
+	if len(frames) != 3 {
+		t.Fatalf("Expected 3 frames but there were %d", len(frames))
+	}
+	for idx, frame := range frames {
+		inner := decode(t, decode(t, frame)[fieldMessage])
+		if inner.varint(fieldQueryPort) != uint64(idx+1) {
+			t.Errorf("Frame %d was not the message that was sent in that order", idx)
+		}
+	}
+}
+
+func TestSocketOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnstap")
+	if err != nil {
+		t.Fatalf("Could not create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Could not listen on a unix socket: %s", err)
+	}
+	defer listener.Close()
+
+	// a reader that accepts the content type, reads the stream, and finishes it
+	received := make(chan [][]byte)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Could not accept the connection: %s", err)
+			close(received)
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		if _, err := readControl(reader, controlReady); err != nil {
+			t.Errorf("Expected the READY control frame: %s", err)
+			close(received)
+			return
+		}
+		writeControl(conn, controlAccept, true)
+		if _, err := readControl(reader, controlStart); err != nil {
+			t.Errorf("Expected the START control frame: %s", err)
+			close(received)
+			return
+		}
+		frames := readFrames(t, reader)
+		writeControl(conn, controlFinish, false)
+		received <- frames
+	}()
+
+	output := NewSocketOutput(path, "host", "gyip", func(err error) { t.Errorf("Unexpected error: %s", err) })
+	output.Send(&Message{Type: AUTH_QUERY, Protocol: TCP})
+	output.Send(&Message{Type: AUTH_RESPONSE, Protocol: TCP})
+	if err := output.Close(); err != nil {
+		t.Fatalf("Could not close the output: %s", err)
+	}
+	if frames := <-received; len(frames) != 2 {
+		t.Errorf("Expected 2 frames but there were %d", len(frames))
+	}
+}
+
+func TestDeadlineConn(t *testing.T) {
+	// nothing reads the other end of the pipe so the write can't finish
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &deadlineConn{Conn: client, timeout: 10 * time.Millisecond}
+	defer conn.Close()
+
+	written := make(chan error)
+	go func() {
+		_, err := conn.Write([]byte("frame"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("Expected the write to time out (was: %v)", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The write to a reader that stopped reading did not time out")
+	}
+}
+
+func TestSocketOutputDrops(t *testing.T) {
+	// nothing is listening so nothing can be written and every message past the queue is dropped
+	output := NewSocketOutput(filepath.Join(os.TempDir(), "gyip-missing-dnstap.sock"), "", "", nil)
+	for idx := 0; idx < queueSize+5; idx++ {
+		output.Send(&Message{Type: AUTH_QUERY})
+	}
+	if output.Dropped() != 5 {
+		t.Errorf("Expected 5 messages to be dropped but %d were", output.Dropped())
+	}
+	if err := output.Close(); err != nil {
+		t.Errorf("Closing an output that never connected should not wait: %s", err)
+	}
+}
@@ -0,0 +1,323 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the content type of a frame stream of dnstap messages
+const ContentType = "protobuf:dnstap.Dnstap"
+
+// the frame stream control frame types
+const (
+	controlAccept = 1
+	controlStart  = 2
+	controlStop   = 3
+	controlReady  = 4
+	controlFinish = 5
+)
+
+// the frame stream control field that holds a content type
+const controlFieldContentType = 1
+
+// the largest control frame that is read
+const maxControlSize = 512
+
+// the number of messages that are waiting to be written before new ones are dropped
+const queueSize = 10000
+
+// how long to wait before connecting to the socket again
+const reconnectInterval = time.Second
+
+// how long a socket has to answer the frame stream handshake and to take each write, a reader that
+// stops reading loses the connection (and the messages are dropped) instead of holding up the output
+const socketTimeout = 5 * time.Second
+
+// how long closing waits for the messages that are waiting to be written
+const closeTimeout = 5 * time.Second
+
+// Output - writes dnstap messages as a frame stream to a file or a unix socket without holding up the
+// sender. messages are dropped when they are sent faster than they can be written (or while the socket
+// can't be reached).
+type Output struct {
+	// updated with sync/atomic, this comes first so that it is 64-bit aligned on 32-bit platforms
+	dropped uint64
+
+	// opens the stream, and does the handshake for a socket
+	open func() (io.WriteCloser, *bufio.Reader, error)
+	// the stream is opened again after an error
+	reconnect bool
+	// written to the outer message of every dnstap message
+	identity []byte
+	version  []byte
+
+	lock    sync.RWMutex
+	closed  bool
+	queue   chan []byte
+	closing chan struct{}
+	done    chan struct{}
+	// called with the errors writing the stream, they are not returned to the sender
+	report func(error)
+}
+
+// NewFileOutput - an output that writes a unidirectional frame stream to a new file at the path (replacing
+// any file that is there). nothing more is written to the file after an error.
+func NewFileOutput(path string, identity string, version string, report func(error)) (*Output, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return newOutput(identity, version, report, false, func() (io.WriteCloser, *bufio.Reader, error) {
+		return file, nil, nil
+	}), nil
+}
+
+// NewSocketOutput - an output that writes a bidirectional frame stream to the unix socket at the path,
+// connecting again whenever the connection is lost
+func NewSocketOutput(path string, identity string, version string, report func(error)) *Output {
+	return newOutput(identity, version, report, true, func() (io.WriteCloser, *bufio.Reader, error) {
+		conn, err := net.DialTimeout("unix", path, socketTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader := bufio.NewReader(conn)
+		conn.SetDeadline(time.Now().Add(socketTimeout))
+		if err := handshake(conn, reader); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return &deadlineConn{Conn: conn, timeout: socketTimeout}, reader, nil
+	})
+}
+
+// a connection that gives each write (and the reads after it) the timeout to finish
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (conn *deadlineConn) Write(data []byte) (int, error) {
+	conn.SetDeadline(time.Now().Add(conn.timeout))
+	return conn.Conn.Write(data)
+}
+
+// starts writing the messages that are sent to the output
+func newOutput(identity string, version string, report func(error), reconnect bool, open func() (io.WriteCloser, *bufio.Reader, error)) *Output {
+	output := &Output{
+		open:      open,
+		reconnect: reconnect,
+		queue:     make(chan []byte, queueSize),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+		report:    report,
+	}
+	if identity != "" {
+		output.identity = []byte(identity)
+	}
+	if version != "" {
+		output.version = []byte(version)
+	}
+	go output.run()
+	return output
+}
+
+// Send - queues the message to be written, it is dropped if too many are already waiting
+func (output *Output) Send(m *Message) {
+	output.lock.RLock()
+	defer output.lock.RUnlock()
+	if output.closed {
+		return
+	}
+	select {
+	case output.queue <- m.Marshal(output.identity, output.version):
+	default:
+		atomic.AddUint64(&output.dropped, 1)
+	}
+}
+
+// Dropped - the number of messages that were not written because too many were waiting
+func (output *Output) Dropped() uint64 {
+	return atomic.LoadUint64(&output.dropped)
+}
+
+// Close - writes the messages that are waiting (for a short while) and ends the stream
+func (output *Output) Close() error {
+	output.lock.Lock()
+	if output.closed {
+		output.lock.Unlock()
+		return nil
+	}
+	output.closed = true
+	close(output.closing)
+	close(output.queue)
+	output.lock.Unlock()
+
+	select {
+	case <-output.done:
+		return nil
+	case <-time.After(closeTimeout):
+		return fmt.Errorf("the dnstap messages that were waiting could not be written after %s", closeTimeout)
+	}
+}
+
+func (output *Output) fail(err error) {
+	if output.report != nil && err != nil {
+		output.report(err)
+	}
+}
+
+// writes the queued messages to the stream, opening it again (when it can be) after an error
+func (output *Output) run() {
+	defer close(output.done)
+	for {
+		stream, reader, err := output.open()
+		if err != nil {
+			output.fail(err)
+			if !output.reconnect {
+				return
+			}
+			// the messages that are waiting can't be written until the stream is open again
+			select {
+			case <-output.closing:
+				return
+			case <-time.After(reconnectInterval):
+			}
+			continue
+		}
+
+		writer := bufio.NewWriter(stream)
+		err = writeControl(writer, controlStart, true)
+		if err == nil {
+			err = output.writeQueued(writer)
+		}
+		if err != nil {
+			output.fail(err)
+			stream.Close()
+			if !output.reconnect {
+				return
+			}
+			continue
+		}
+
+		// the queue was closed, end the stream
+		err = writeControl(writer, controlStop, false)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err == nil && reader != nil {
+			_, err = readControl(reader, controlFinish)
+		}
+		output.fail(err)
+		stream.Close()
+		return
+	}
+}
+
+// writes messages as they are queued until the queue is closed (nil) or the stream fails (the error)
+func (output *Output) writeQueued(writer *bufio.Writer) error {
+	for {
+		var frame []byte
+		var open bool
+		// only flush once nothing else is waiting
+		select {
+		case frame, open = <-output.queue:
+		default:
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			frame, open = <-output.queue
+		}
+		if !open {
+			return nil
+		}
+		if err := writeFrame(writer, frame); err != nil {
+			return err
+		}
+	}
+}
+
+// writes a data frame: the length and then the data
+func writeFrame(w io.Writer, frame []byte) error {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(frame)))
+	if _, err := w.Write(length); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// writes a control frame: a zero length escape, the length of the control frame, the control type,
+// and the content type when it has one
+func writeControl(w io.Writer, controlType uint32, withContentType bool) error {
+	control := make([]byte, 4)
+	binary.BigEndian.PutUint32(control, controlType)
+	if withContentType {
+		field := make([]byte, 8)
+		binary.BigEndian.PutUint32(field, controlFieldContentType)
+		binary.BigEndian.PutUint32(field[4:], uint32(len(ContentType)))
+		control = append(append(control, field...), ContentType...)
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(control)))
+	_, err := w.Write(append(header, control...))
+	return err
+}
+
+// reads a control frame of the expected type and returns the content types in it
+func readControl(r io.Reader, expected uint32) ([]string, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if binary.BigEndian.Uint32(header) != 0 || length < 4 || length > maxControlSize {
+		return nil, fmt.Errorf("expected a frame stream control frame")
+	}
+	control := make([]byte, length)
+	if _, err := io.ReadFull(r, control); err != nil {
+		return nil, err
+	}
+	if controlType := binary.BigEndian.Uint32(control); controlType != expected {
+		return nil, fmt.Errorf("expected frame stream control frame %d but got %d", expected, controlType)
+	}
+
+	contentTypes := []string{}
+	for fields := control[4:]; len(fields) >= 8; {
+		fieldType := binary.BigEndian.Uint32(fields)
+		fieldLength := binary.BigEndian.Uint32(fields[4:])
+		if uint32(len(fields)-8) < fieldLength {
+			return nil, fmt.Errorf("the frame stream control frame is too short")
+		}
+		if fieldType == controlFieldContentType {
+			contentTypes = append(contentTypes, string(fields[8:8+fieldLength]))
+		}
+		fields = fields[8+fieldLength:]
+	}
+	return contentTypes, nil
+}
+
+// the writer's half of the bidirectional handshake: READY with the content type, the reader ACCEPTs it
+func handshake(w io.Writer, r io.Reader) error {
+	if err := writeControl(w, controlReady, true); err != nil {
+		return err
+	}
+	contentTypes, err := readControl(r, controlAccept)
+	if err != nil {
+		return err
+	}
+	for _, contentType := range contentTypes {
+		if contentType == ContentType {
+			return nil
+		}
+	}
+	return fmt.Errorf("the dnstap reader did not accept %s", ContentType)
+}
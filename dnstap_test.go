@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chrisruffalo/gyip/dnstap"
+	"github.com/miekg/dns"
+)
+
+func TestTap(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	dir, err := ioutil.TempDir("", "gyip")
+	if err != nil {
+		t.Fatalf("Could not create a directory: %s", err)
+	}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		dnstapOutput = nil
+		os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "gyip.dnstap")
+	if err := configureDnstap(DnstapConfig{File: path, Identity: "tapped"}); err != nil {
+		t.Fatalf("Could not configure dnstap: %s", err)
+	}
+
+	handler := tap(dns.HandlerFunc(handleQuestions), "udp")
+	question := new(dns.Msg)
+	question.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.0.0.100"), Port: 5353}}
+	handler.ServeDNS(w, question)
+	// the questions the server asks itself are not tapped
+	readiness := new(dns.Msg)
+	readiness.SetQuestion(readinessLabel+".gyip.io.", dns.TypeA)
+	handler.ServeDNS(&testWriter{remote: w.remote}, readiness)
+	dnstapOutput.Close()
+
+	stream, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read the dnstap file: %s", err)
+	}
+	packedQuestion, _ := question.Pack()
+	packedResponse, _ := w.written.Pack()
+	packedReadiness, _ := readiness.Pack()
+	for _, expected := range [][]byte{[]byte(dnstap.ContentType), []byte("tapped"), packedQuestion, packedResponse, net.ParseIP("10.0.0.100").To4(), packedName("gyip.io.")} {
+		if !bytes.Contains(stream, expected) {
+			t.Errorf("The dnstap file did not contain %v", expected)
+		}
+	}
+	if bytes.Count(stream, packedQuestion) != 2 {
+		t.Errorf("The question should be in both the query and the response message")
+	}
+	if bytes.Contains(stream, packedReadiness) {
+		t.Errorf("The question the server asked itself should not have been tapped")
+	}
+}
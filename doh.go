@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// the media type of dns wire format messages (rfc 8484)
+const dnsMessageType = "application/dns-message"
+
+// the media type of the json api
+const dnsJSONType = "application/dns-json"
+
+// the largest dns message that will be read from a request
+const maxDoHMessageSize = 65535
+
+// collects the response written by the dns handler so that it can be sent back over http
+type httpResponseWriter struct {
+	local   net.Addr
+	remote  net.Addr
+	written *dns.Msg
+	closed  bool
+}
+
+func (w *httpResponseWriter) LocalAddr() net.Addr {
+	return w.local
+}
+
+func (w *httpResponseWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+func (w *httpResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.written = m
+	return nil
+}
+
+func (w *httpResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.written = m
+	return len(b), nil
+}
+
+func (w *httpResponseWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *httpResponseWriter) TsigStatus() error {
+	return nil
+}
+
+func (w *httpResponseWriter) TsigTimersOnly(bool) {
+}
+
+func (w *httpResponseWriter) Hijack() {
+}
+
+// the address of an http client as a tcp address so that it is treated like any other tcp client
+func httpAddr(address string) net.Addr {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	portNumber, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: portNumber}
+}
+
+// answers dns over https: rfc 8484 GET and POST requests on /dns-query and the json api on /resolve
+// (or on /dns-query when json is asked for). every question goes through the given dns handler.
+func dohHandler(next dns.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && (r.URL.Query().Get("name") != "" || strings.Contains(r.Header.Get("Accept"), dnsJSONType)) {
+			serveDoHJSON(next, w, r)
+			return
+		}
+		serveDoHMessage(next, w, r)
+	})
+	mux.HandleFunc("/resolve", func(w http.ResponseWriter, r *http.Request) {
+		serveDoHJSON(next, w, r)
+	})
+	return mux
+}
+
+// passes the question to the dns handler and returns the response, nil when the handler didn't respond
+func exchangeDoH(next dns.Handler, r *http.Request, question *dns.Msg) *dns.Msg {
+	writer := &httpResponseWriter{remote: httpAddr(r.RemoteAddr)}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		writer.local = local
+	}
+	next.ServeDNS(writer, question)
+	if writer.closed {
+		return nil
+	}
+	return writer.written
+}
+
+// the number of seconds an http cache can keep the response, the smallest ttl of the answers
+func cacheSeconds(m *dns.Msg) (uint32, bool) {
+	if len(m.Answer) < 1 {
+		return 0, false
+	}
+	ttl := m.Answer[0].Header().Ttl
+	for _, rr := range m.Answer[1:] {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl, true
+}
+
+// rfc 8484 wire format requests, a base64url encoded message in the dns parameter of a GET or the body of a POST
+func serveDoHMessage(next dns.Handler, w http.ResponseWriter, r *http.Request) {
+	var packed []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		encoded := strings.TrimRight(r.URL.Query().Get("dns"), "=")
+		if encoded == "" {
+			http.Error(w, "the dns parameter is required", http.StatusBadRequest)
+			return
+		}
+		packed, err = base64.RawURLEncoding.DecodeString(encoded)
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, fmt.Sprintf("the content type must be %s", dnsMessageType), http.StatusUnsupportedMediaType)
+			return
+		}
+		packed, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxDoHMessageSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "only GET and POST are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("the dns message could not be read: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	question := new(dns.Msg)
+	if err := question.Unpack(packed); err != nil {
+		parseFailures.Inc("doh")
+		http.Error(w, fmt.Sprintf("the dns message is not valid: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	response := exchangeDoH(next, r, question)
+	if response == nil {
+		http.Error(w, "the question was not answered", http.StatusForbidden)
+		return
+	}
+	packed, err = response.Pack()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("the response could not be packed: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dnsMessageType)
+	if ttl, ok := cacheSeconds(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+	}
+	w.Write(packed)
+}
+
+// the question and answer sections of the json api
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type dohJSONAnswer struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// the response of the json api, in the same shape as the json apis of the public resolvers
+type dohJSONResponse struct {
+	Status   int               `json:"Status"`
+	TC       bool              `json:"TC"`
+	RD       bool              `json:"RD"`
+	RA       bool              `json:"RA"`
+	AD       bool              `json:"AD"`
+	CD       bool              `json:"CD"`
+	Question []dohJSONQuestion `json:"Question"`
+	Answer   []dohJSONAnswer   `json:"Answer,omitempty"`
+}
+
+// the json api, a GET with the name and (optionally) the type as a name or number
+func serveDoHJSON(next dns.Handler, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" || len(name) > 253 {
+		http.Error(w, "the name parameter is required", http.StatusBadRequest)
+		return
+	}
+	qtype := dns.TypeA
+	if typeName := query.Get("type"); typeName != "" {
+		if number, err := strconv.ParseUint(typeName, 10, 16); err == nil {
+			qtype = uint16(number)
+		} else if known, found := dns.StringToType[strings.ToUpper(typeName)]; found {
+			qtype = known
+		} else {
+			http.Error(w, fmt.Sprintf("the type \"%s\" is not known", typeName), http.StatusBadRequest)
+			return
+		}
+	}
+
+	question := new(dns.Msg)
+	question.SetQuestion(dns.Fqdn(name), qtype)
+	question.CheckingDisabled = query.Get("cd") == "1" || query.Get("cd") == "true"
+	if query.Get("do") == "1" || query.Get("do") == "true" {
+		question.SetEdns0(dns.DefaultMsgSize, true)
+	}
+
+	response := exchangeDoH(next, r, question)
+	if response == nil {
+		http.Error(w, "the question was not answered", http.StatusForbidden)
+		return
+	}
+
+	body := dohJSONResponse{
+		Status: response.Rcode,
+		TC:     response.Truncated,
+		RD:     response.RecursionDesired,
+		RA:     response.RecursionAvailable,
+		AD:     response.AuthenticatedData,
+		CD:     response.CheckingDisabled,
+	}
+	for _, q := range response.Question {
+		body.Question = append(body.Question, dohJSONQuestion{Name: q.Name, Type: q.Qtype})
+	}
+	for _, rr := range response.Answer {
+		header := rr.Header()
+		// the data is the presentation format of the record without the header
+		data := strings.TrimPrefix(rr.String(), header.String())
+		body.Answer = append(body.Answer, dohJSONAnswer{Name: header.Name, Type: header.Rrtype, TTL: header.Ttl, Data: data})
+	}
+	// the extended response code is kept in the OPT record
+	if opt := response.IsEdns0(); opt != nil {
+		body.Status |= int(opt.Hdr.Ttl>>24) << 4
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if ttl, ok := cacheSeconds(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// serves dns over https on the socket with the shared certificate, started is called once it is being served
+func serveDoH(s *socket, handler dns.Handler, started func()) error {
+	server := &http.Server{Handler: dohHandler(handler), TLSConfig: serverTLS}
+	addRunningHTTPServer(server)
+	s.stop = func() {
+		removeRunningSocket(s)
+		server.Close()
+	}
+	started()
+	if err := server.ServeTLS(s.listener, "", ""); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDoHMessage(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	server := httptest.NewServer(dohHandler(edns(dns.HandlerFunc(handleQuestions))))
+	defer server.Close()
+
+	question := new(dns.Msg)
+	question.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+	question.Id = 0
+	packed, _ := question.Pack()
+
+	get, err := http.Get(server.URL + "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(packed))
+	if err != nil {
+		t.Fatalf("Could not ask question with GET: %s", err)
+	}
+	post, err := http.Post(server.URL+"/dns-query", dnsMessageType, bytes.NewReader(packed))
+	if err != nil {
+		t.Fatalf("Could not ask question with POST: %s", err)
+	}
+
+	for _, httpResponse := range []*http.Response{get, post} {
+		body, _ := ioutil.ReadAll(httpResponse.Body)
+		httpResponse.Body.Close()
+		if httpResponse.StatusCode != http.StatusOK || httpResponse.Header.Get("Content-Type") != dnsMessageType {
+			t.Errorf("The %s request did not succeed (status: %d, content type: %s)", httpResponse.Request.Method, httpResponse.StatusCode, httpResponse.Header.Get("Content-Type"))
+			continue
+		}
+		if httpResponse.Header.Get("Cache-Control") != "max-age=43200" {
+			t.Errorf("The %s request did not have the expected cache control (was: %s)", httpResponse.Request.Method, httpResponse.Header.Get("Cache-Control"))
+		}
+		response := new(dns.Msg)
+		if err := response.Unpack(body); err != nil {
+			t.Errorf("The %s response could not be unpacked: %s", httpResponse.Request.Method, err)
+			continue
+		}
+		if len(response.Answer) != 1 || !response.Answer[0].(*dns.A).A.Equal(net.ParseIP("10.0.0.1")) {
+			t.Errorf("The %s response did not have the expected answer (was: %v)", httpResponse.Request.Method, response.Answer)
+		}
+	}
+
+	data := []struct {
+		method      string
+		path        string
+		contentType string
+		expected    int
+	}{
+		{http.MethodGet, "/dns-query", "", http.StatusBadRequest},
+		{http.MethodGet, "/dns-query?dns=not-a-message", "", http.StatusBadRequest},
+		{http.MethodPost, "/dns-query", "text/plain", http.StatusUnsupportedMediaType},
+		{http.MethodPut, "/dns-query", dnsMessageType, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/other", "", http.StatusNotFound},
+	}
+	for _, item := range data {
+		request, _ := http.NewRequest(item.method, server.URL+item.path, bytes.NewReader(packed))
+		request.Header.Set("Content-Type", item.contentType)
+		httpResponse, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Could not make request %s %s: %s", item.method, item.path, err)
+			continue
+		}
+		httpResponse.Body.Close()
+		if httpResponse.StatusCode != item.expected {
+			t.Errorf("The request %s %s did not get the expected status (was: %d, expected %d)", item.method, item.path, httpResponse.StatusCode, item.expected)
+		}
+	}
+}
+
+func TestDoHJSON(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	server := httptest.NewServer(dohHandler(edns(dns.HandlerFunc(handleQuestions))))
+	defer server.Close()
+
+	data := []struct {
+		path           string
+		expectedStatus int
+		expectedData   []string
+	}{
+		{"/resolve?name=10.0.0.1.gyip.io", dns.RcodeSuccess, []string{"10.0.0.1"}},
+		{"/resolve?name=::1.gyip.io&type=AAAA", dns.RcodeSuccess, []string{"::1"}},
+		{"/resolve?name=10.0.0.1.10.0.0.2.gyip.io&type=1", dns.RcodeSuccess, []string{"10.0.0.1", "10.0.0.2"}},
+		{"/dns-query?name=nothing.gyip.io", dns.RcodeNameError, []string{}},
+	}
+
+	for _, item := range data {
+		httpResponse, err := http.Get(server.URL + item.path)
+		if err != nil {
+			t.Errorf("Could not ask '%s': %s", item.path, err)
+			continue
+		}
+		var body dohJSONResponse
+		err = json.NewDecoder(httpResponse.Body).Decode(&body)
+		httpResponse.Body.Close()
+		if err != nil {
+			t.Errorf("The response to '%s' could not be decoded: %s", item.path, err)
+			continue
+		}
+		if body.Status != item.expectedStatus || len(body.Question) != 1 {
+			t.Errorf("The response to '%s' did not have the expected status (was: %d, expected %d)", item.path, body.Status, item.expectedStatus)
+		}
+		if len(body.Answer) != len(item.expectedData) {
+			t.Errorf("The response to '%s' did not have the expected answers (was: %v)", item.path, body.Answer)
+			continue
+		}
+		for idx, answer := range body.Answer {
+			if answer.Data != item.expectedData[idx] {
+				t.Errorf("The response to '%s' did not have the expected answer (was: %s, expected %s)", item.path, answer.Data, item.expectedData[idx])
+			}
+		}
+	}
+
+	httpResponse, err := http.Get(server.URL + "/resolve?name=gyip.io&type=NOPE")
+	if err != nil || httpResponse.StatusCode != http.StatusBadRequest {
+		t.Errorf("An unknown type should not be accepted")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// the only version of edns that is supported
+const ednsVersion = 0
+
+// the udp buffer size used when none is configured, small enough to avoid ip fragmentation
+const defaultEDNSBufferSize = 1232
+
+// the udp buffer size advertised in responses (from the combined configuration file and command line)
+var ednsBufferSize uint16 = defaultEDNSBufferSize
+
+// EDNSConfig - options for edns0
+type EDNSConfig struct {
+	// the largest udp response that will be sent, also advertised to clients in the OPT record
+	BufferSize uint16 `json:"bufferSize" yaml:"bufferSize" toml:"bufferSize"`
+}
+
+// adds edns information to responses and makes sure udp responses fit in the size the client can accept
+type ednsWriter struct {
+	dns.ResponseWriter
+	request *dns.Msg
+}
+
+func (w *ednsWriter) WriteMsg(m *dns.Msg) error {
+	_, isUDP := w.RemoteAddr().(*net.UDPAddr)
+	fitResponse(w.request, m, isUDP)
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// wraps the handler so that requests with an unsupported edns version are answered with BADVERS and
+// every response is given an OPT record (when the request had one) and truncated to fit the client
+func edns(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if opt := r.IsEdns0(); opt != nil && opt.Version() != ednsVersion {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Compress = compressReplies
+			setResponseEdns(r, m, dns.RcodeBadVers)
+			w.WriteMsg(m)
+			return
+		}
+		next.ServeDNS(&ednsWriter{ResponseWriter: w, request: r}, r)
+	})
+}
+
+// adds our OPT record to the response when the request had one. extended response codes
+// (like BADVERS) are kept in the OPT record and the response code holds the lower four bits.
+func setResponseEdns(request *dns.Msg, response *dns.Msg, rcode int) {
+	requestOpt := request.IsEdns0()
+	if requestOpt == nil || response.IsEdns0() != nil {
+		return
+	}
+
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(ednsBufferSize)
+	opt.SetVersion(ednsVersion)
+	// the DO bit is copied from the request (rfc 3225)
+	if requestOpt.Do() {
+		opt.SetDo()
+	}
+	opt.Hdr.Ttl |= uint32(rcode>>4) << 24
+	response.Rcode = rcode & 0xF
+	response.Extra = append(response.Extra, opt)
+}
+
+// the full response code of the response, the upper bits of an extended response code are in the OPT record
+func extendedRcode(response *dns.Msg) int {
+	rcode := response.Rcode
+	if opt := response.IsEdns0(); opt != nil {
+		rcode |= int(opt.Hdr.Ttl>>24) << 4
+	}
+	return rcode
+}
+
+// the largest udp response that the client can accept
+func maxResponseSize(request *dns.Msg) int {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return dns.MinMsgSize
+	}
+	size := int(opt.UDPSize())
+	if size < dns.MinMsgSize {
+		size = dns.MinMsgSize
+	}
+	if size > int(ednsBufferSize) && ednsBufferSize >= dns.MinMsgSize {
+		size = int(ednsBufferSize)
+	}
+	return size
+}
+
+// gives the response an OPT record and, for udp, removes answers from the end of the response until it
+// fits in the size the client can accept. truncated responses have the TC bit set so that the client
+// can ask again over tcp.
+func fitResponse(request *dns.Msg, response *dns.Msg, isUDP bool) {
+	setResponseEdns(request, response, response.Rcode)
+	if !isUDP {
+		return
+	}
+
+	size := maxResponseSize(request)
+	if response.Len() <= size {
+		return
+	}
+
+	response.Truncated = true
+	for len(response.Answer) > 0 && response.Len() > size {
+		response.Answer = response.Answer[:len(response.Answer)-1]
+	}
+	for len(response.Ns) > 0 && response.Len() > size {
+		response.Ns = response.Ns[:len(response.Ns)-1]
+	}
+}
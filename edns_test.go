@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// a question name with enough addresses that the answer doesn't fit in 512 bytes
+func largeQuestion() string {
+	ips := []string{}
+	for i := 1; i <= 20; i++ {
+		ips = append(ips, net.IPv4(10, 0, 0, byte(i)).String())
+	}
+	return strings.Join(ips, ".") + ".gyip.io."
+}
+
+func TestEdns(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	handler := edns(dns.HandlerFunc(handleQuestions))
+
+	udp := &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	tcp := &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
+
+	data := []struct {
+		remote    net.Addr
+		edns      bool
+		size      uint16
+		do        bool
+		truncated bool
+		maxSize   int
+	}{
+		// no edns is limited to 512 bytes
+		{udp, false, 0, false, true, 512},
+		// the client size is respected
+		{udp, true, 1024, false, true, 1024},
+		// but never larger than our own buffer size
+		{udp, true, 65000, true, true, int(defaultEDNSBufferSize)},
+		// tcp is never truncated
+		{tcp, false, 0, false, false, 65535},
+		{tcp, true, 512, false, false, 65535},
+	}
+
+	for _, item := range data {
+		w := &testWriter{remote: item.remote}
+		r := new(dns.Msg)
+		r.SetQuestion(largeQuestion(), dns.TypeA)
+		if item.edns {
+			r.SetEdns0(item.size, item.do)
+		}
+		handler.ServeDNS(w, r)
+
+		if w.written == nil {
+			t.Errorf("No response was written to %s (edns: %t, size: %d)", item.remote, item.edns, item.size)
+			continue
+		}
+		if w.written.Truncated != item.truncated {
+			t.Errorf("The response to %s was not truncated as expected (edns: %t, size: %d, was: %t, expected %t)", item.remote, item.edns, item.size, w.written.Truncated, item.truncated)
+		}
+		if w.written.Len() > item.maxSize {
+			t.Errorf("The response to %s was too large (edns: %t, size: %d, was: %d, max %d)", item.remote, item.edns, item.size, w.written.Len(), item.maxSize)
+		}
+		if !item.truncated && len(w.written.Answer) != 20 {
+			t.Errorf("The response to %s did not have every answer (was: %d)", item.remote, len(w.written.Answer))
+		}
+
+		opt := w.written.IsEdns0()
+		if item.edns != (opt != nil) {
+			t.Errorf("The response to %s did not have an OPT record as expected (edns: %t, found: %t)", item.remote, item.edns, opt != nil)
+		}
+		if opt != nil && (opt.UDPSize() != ednsBufferSize || opt.Do() != item.do) {
+			t.Errorf("The response OPT record to %s was not as expected (size: %d, do: %t)", item.remote, opt.UDPSize(), opt.Do())
+		}
+	}
+}
+
+func TestEdnsCompressed(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	compressReplies = true
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		compressReplies = false
+	}()
+
+	// with compression every answer fits in the buffer the client asked for
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}}
+	r := new(dns.Msg)
+	r.SetQuestion(largeQuestion(), dns.TypeA)
+	r.SetEdns0(1232, false)
+	edns(dns.HandlerFunc(handleQuestions)).ServeDNS(w, r)
+
+	if w.written == nil || w.written.Truncated || len(w.written.Answer) != 20 {
+		t.Errorf("The compressed response should not be truncated (was: %v)", w.written)
+	}
+}
+
+func TestEdnsBadVersion(t *testing.T) {
+	answered := false
+	handler := edns(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		answered = true
+	}))
+
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}}
+	r := new(dns.Msg)
+	r.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	r.IsEdns0().SetVersion(1)
+	handler.ServeDNS(w, r)
+
+	if answered {
+		t.Errorf("A request with an unsupported edns version should not be answered")
+	}
+	if w.written == nil || w.written.IsEdns0() == nil {
+		t.Fatalf("A BADVERS response with an OPT record was not written (was: %v)", w.written)
+	}
+
+	// the response must survive the wire with the extended response code intact
+	packed, err := w.written.Pack()
+	if err != nil {
+		t.Fatalf("The BADVERS response could not be packed: %s", err)
+	}
+	unpacked := new(dns.Msg)
+	if err := unpacked.Unpack(packed); err != nil {
+		t.Fatalf("The BADVERS response could not be unpacked: %s", err)
+	}
+	if rcode := extendedRcode(unpacked); rcode != dns.RcodeBadVers {
+		t.Errorf("The response code was not BADVERS (was: %d)", rcode)
+	}
+	if unpacked.IsEdns0().Version() != ednsVersion {
+		t.Errorf("The response did not advertise the supported version (was: %d)", unpacked.IsEdns0().Version())
+	}
+}
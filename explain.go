@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the first label of a TXT question that asks why the rest of the name is answered the way it is
+// (ex: "explain.virthost10.10.1.1.1.gyip.io"), it is honored like the other keywords
+const explainLabel = "explain"
+
+// the longest string that a TXT record can hold
+const maxTXTString = 255
+
+// the name that a TXT question for explain.<name> asks about and the domain it is in, empty when the
+// question isn't one or the domain does not honor the explain keyword
+func explainTarget(questionName string) (string, string) {
+	prefix := explainLabel + "."
+	if len(questionName) <= len(prefix) || strings.ToLower(questionName[:len(prefix)]) != prefix {
+		return "", ""
+	}
+	target := questionName[len(prefix):]
+	domain := domainOf(target)
+	if domain == "" || !findDomain(domain).allowsKeyword(explainLabel) {
+		return "", ""
+	}
+	return target, domain
+}
+
+// answers a TXT question for explain.<name> with how an A or AAAA question for the name would be answered
+func explainQuestion(w dns.ResponseWriter, message *dns.Msg, q dns.Question) {
+	target, domain := explainTarget(q.Name)
+	if target == "" {
+		return
+	}
+	lines := explain(remoteIP(w.RemoteAddr()), target, domain)
+	for idx, line := range lines {
+		if len(line) > maxTXTString {
+			lines[idx] = line[:maxTXTString]
+		}
+	}
+	// the lines are the strings of one record so that they stay in order
+	message.Answer = append(message.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+		Txt: lines,
+	})
+}
+
+// each step of resolving the name in the domain, one line for each
+func explain(ip net.IP, questionName string, domain string) []string {
+	lines := []string{fmt.Sprintf("domain: %s", domain)}
+	resolved, err := resolveName(ip, questionName, domain)
+	if resolved == nil {
+		return append(lines, "result: NXDOMAIN, the name has nothing before the domain")
+	}
+
+	if resolved.registered {
+		lines = append(lines, "record: the name was registered through the records api")
+	}
+	if !resolved.expires.IsZero() {
+		lines = append(lines, fmt.Sprintf("lease: ends at %s", resolved.expires.UTC().Format(time.RFC3339)))
+	}
+	if resolved.ignored != "" {
+		lines = append(lines, fmt.Sprintf("ignored: %s", resolved.ignored))
+	}
+	if resolved.keyword != "" {
+		lines = append(lines, fmt.Sprintf("keyword: %s answers with the client address", resolved.keyword))
+	}
+	if resolved.cmd != nil {
+		lines = append(lines, fmt.Sprintf("command: %s", resolved.cmd.Type()))
+	}
+	for _, token := range resolved.tokens {
+		if token.ip == nil {
+			lines = append(lines, fmt.Sprintf("token: %s is not an address", token.text))
+		} else if token.ip.To4() != nil {
+			lines = append(lines, fmt.Sprintf("token: %s is the ipv4 address %s", token.text, token.ip))
+		} else {
+			lines = append(lines, fmt.Sprintf("token: %s is the ipv6 address %s", token.text, token.ip))
+		}
+	}
+	for _, skipped := range resolved.skipped {
+		lines = append(lines, fmt.Sprintf("skipped: %s, %s", skipped.ip, skipped.reason))
+	}
+	if err == errRefused {
+		return append(lines, fmt.Sprintf("result: REFUSED, %s", resolved.refused))
+	}
+
+	if len(resolved.ips) < 1 {
+		return append(lines, "result: NXDOMAIN, there are no addresses to answer with")
+	}
+	for _, answer := range resolved.ips {
+		lines = append(lines, fmt.Sprintf("answer: %s", answer))
+	}
+	return append(lines, fmt.Sprintf("ttl: %d", resolved.ttl))
+}
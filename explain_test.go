@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTokenizeIPs(t *testing.T) {
+	data := []struct {
+		name     string
+		expected []string
+	}{
+		{"virthost10.10.1.1.1", []string{"virthost10", "10.1.1.1"}},
+		{"virthost.10.10.1.1", []string{"virthost", "10.10.1.1"}},
+		{"10.0.0.1.and.10.5.4.1", []string{"10.0.0.1", "and", "10.5.4.1"}},
+		{"10.27.14.34.45.337.0.1", []string{"10", "27.14.34.45", "337.0.1"}},
+		{"10.0.0.1.::1", []string{"10.0.0.1", "::1"}},
+		{"nothing.here", []string{"nothing.here"}},
+		{"a.10.0.0.1", []string{"a", "10.0.0.1"}},
+		{"x.y", []string{"x.y"}},
+		{"", nil},
+	}
+
+	for _, item := range data {
+		var tokens []string
+		for _, token := range tokenizeIPs(item.name) {
+			tokens = append(tokens, token.text)
+		}
+		if !reflect.DeepEqual(tokens, item.expected) {
+			t.Errorf("The name '%s' was not tokenized as expected (was: %v, expected: %v)", item.name, tokens, item.expected)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	servingDomains = []*DomainConfig{
+		{Name: "gyip.io."},
+		{Name: "strict.io.", Commands: []string{}, Encodings: []string{"ipv4"}, Disallowed: "refuse"},
+		{Name: "quiet.io.", Keywords: []string{"echo"}},
+	}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	data := []struct {
+		question string
+		expected []string
+	}{
+		{"explain.virthost10.10.1.1.1.gyip.io.", []string{"domain: gyip.io.", "command: noop", "token: virthost10 is not an address", "token: 10.1.1.1 is the ipv4 address 10.1.1.1", "answer: 10.1.1.1", "ttl: 43200"}},
+		{"EXPLAIN.10.0.0.1.10.0.0.2.rr.gyip.io.", []string{"command: rr", "ttl: 10"}},
+		{"explain.echo.gyip.io.", []string{"keyword: echo answers with the client address", "answer: 10.0.0.100"}},
+		{"explain.nothing.gyip.io.", []string{"token: nothing is not an address", "result: NXDOMAIN, there are no addresses to answer with"}},
+		{"explain.gyip.io.", []string{"result: NXDOMAIN, the name has nothing before the domain"}},
+		{"explain.10.0.0.1.::1.strict.io.", []string{"skipped: ::1, the domain does not honor its encoding", "result: REFUSED, ::1: the domain does not honor its encoding"}},
+		{"explain.10.0.0.1.rr.strict.io.", []string{"result: REFUSED, the domain does not honor the command rr"}},
+		{"explain." + strings.Repeat("a.", 110) + "10.0.0.1.gyip.io.", []string{"answer: 10.0.0.1"}},
+		// the explain keyword is not honored
+		{"explain.10.0.0.1.quiet.io.", []string{}},
+		{"explain.10.0.0.1.other.io.", []string{}},
+	}
+
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.0.0.100"), Port: 5353}}
+	for _, item := range data {
+		question := new(dns.Msg)
+		question.SetQuestion(item.question, dns.TypeTXT)
+		handleQuestions(w, question)
+
+		lines := []string{}
+		for _, rr := range w.written.Answer {
+			lines = append(lines, rr.(*dns.TXT).Txt...)
+		}
+		if len(item.expected) < 1 && (len(lines) > 0 || w.written.Rcode != dns.RcodeNameError) {
+			t.Errorf("The question '%s' should not have been explained (was: %v)", item.question, lines)
+		}
+		for _, expected := range item.expected {
+			found := false
+			for _, line := range lines {
+				found = found || line == expected
+			}
+			if !found {
+				t.Errorf("The explanation of '%s' did not have the line '%s' (was: %v)", item.question, expected, lines)
+			}
+		}
+	}
+
+	// the name is only explained for TXT questions
+	question := new(dns.Msg)
+	question.SetQuestion("explain.10.0.0.1.gyip.io.", dns.TypeA)
+	handleQuestions(w, question)
+	if len(w.written.Answer) != 1 || w.written.Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Errorf("An A question for an explain name should be answered like any other (was: %v)", w.written.Answer)
+	}
+}
@@ -13,32 +13,80 @@ import (
 	"time"
 
 	"github.com/chrisruffalo/gyip/command"
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/chrisruffalo/gyip/rrl"
 	"github.com/miekg/dns"
 )
 
 // pick up version from build
-var	Version = "SNAPSHOT"
+var Version = "SNAPSHOT"
 var GitHash = ""
 var LongVersion = Version
 
 // regexp expression to match hostnames
 var validHostnameRegexMatcher, _ = regexp.Compile("^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\\-]*[a-zA-Z0-9])\\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\\-]*[A-Za-z0-9])$")
-var servingDomains = []string{}
+var servingDomains = []*DomainConfig{}
+
+// runtime options (from the combined configuration file and command line)
+var (
+	compressReplies = false
+)
 
 // command line options (from flag import)
 var (
-	hosts    = flag.String("host", "0.0.0.0", "The host to bind to. Can be a comma-seperated list of hosts. (Ex: \"--host 127.0.0.1,10.0.0.1\")")
-	domain   = flag.String("domain", "", "Required. The hosting domain to provide authority/answers for. Can be a comma-separated list of domains. (Ex: \"--domain gyip.io,gyip.net\")")
-	port     = flag.String("port", "8053", "The port to bind the service to (tcp and udp), defaults to 8053")
-	tcpOff   = flag.Bool("tcpOff", false, "Disable listening on TCP, defaults to false")
-	udpOff   = flag.Bool("udpOff", false, "Disable listening on UDP, defaults to false")
-	compress = flag.Bool("compress", false, "Compress replies, defaults to false")
+	hosts              = flag.String("host", "0.0.0.0", "The host to bind to: an address, an interface name, or a host name. Can be a comma-seperated list of hosts. (Ex: \"--host 127.0.0.1,eth0\")")
+	domain             = flag.String("domain", "", "Required unless given in the config file. The hosting domain to provide authority/answers for. Can be a comma-separated list of domains. (Ex: \"--domain gyip.io,gyip.net\")")
+	port               = flag.String("port", "8053", "The port to bind the service to (tcp and udp), defaults to 8053")
+	tcpOff             = flag.Bool("tcpOff", false, "Disable listening on TCP, defaults to false")
+	udpOff             = flag.Bool("udpOff", false, "Disable listening on UDP, defaults to false")
+	compress           = flag.Bool("compress", false, "Compress replies, defaults to false")
+	answers            = flag.String("answers", defaultAnswerPreset, "The addresses that can be given in answers: \"any\", \"safe\" (no link-local, multicast, or cloud metadata addresses), \"private\" (rfc1918, unique local, and loopback), or \"loopback\". Defaults to \"safe\"")
+	allowClients       = flag.String("allowClients", "", "Only answer clients in these networks. Can be a comma-separated list of networks. (Ex: \"--allowClients 10.0.0.0/8,127.0.0.1\")")
+	denyClients        = flag.String("denyClients", "", "Never answer clients in these networks. Can be a comma-separated list of networks. (Ex: \"--denyClients 10.0.5.0/24\")")
+	dropDenied         = flag.Bool("dropDenied", false, "Send nothing back to denied clients instead of a REFUSED answer, defaults to false")
+	rateLimitResponses = flag.Int("rateLimit", 0, "The number of UDP responses of each kind (answer, nxdomain, error) a client network can get each second, defaults to 0 (no limit)")
+	rateLimitWindow    = flag.Int("rateLimitWindow", 15, "The number of seconds over which rate limited responses are counted, defaults to 15")
+	rateLimitSlip      = flag.Int("rateLimitSlip", 2, "Every nth rate limited response is sent back truncated instead of dropped (0 never), defaults to 2")
+	bufferSize         = flag.Int("ednsBufferSize", defaultEDNSBufferSize, "The largest UDP response that will be sent to EDNS clients, defaults to 1232")
+	dot                = flag.Bool("dot", false, "Also listen for DNS over TLS on each host, requires a certificate, defaults to false")
+	dotPort            = flag.String("dotPort", "853", "The port to listen for DNS over TLS on, defaults to 853")
+	doh                = flag.Bool("doh", false, "Also listen for DNS over HTTPS on each host, requires a certificate, defaults to false")
+	dohPort            = flag.String("dohPort", "443", "The port to listen for DNS over HTTPS on, defaults to 443")
+	tlsCert            = flag.String("tlsCert", "", "The PEM encoded certificate (chain) for DNS over TLS and HTTPS")
+	tlsKey             = flag.String("tlsKey", "", "The PEM encoded private key for DNS over TLS and HTTPS")
+	tlsSelfSigned      = flag.Bool("tlsSelfSigned", false, "Generate a self-signed certificate for DNS over TLS and HTTPS when no certificate is given (for testing only), defaults to false")
+	shutdownGrace      = flag.Int("shutdownGrace", defaultGracePeriod, "The number of seconds that outstanding responses are given to finish when the server is stopped, defaults to 10")
+	sockets            = flag.Int("sockets", 1, "The number of sockets to open for each address and transport, more than one are shared with SO_REUSEPORT so that the kernel can spread queries across them, defaults to 1")
+	listenSpecs        = listenFlag{}
+	logQueries         = flag.Bool("logQueries", true, "Log every question along with how it was answered, defaults to true")
+	querySampleRate    = flag.Int("querySample", 1, "Only log one in this many questions, defaults to 1 (every question)")
+	queryLogPath       = flag.String("queryLog", "", "Write the query log to this file instead of the output")
+	queryLogMaxSize    = flag.Int("queryLogMaxSize", 0, "Rotate the query log file before it grows past this many megabytes, defaults to 0 (never)")
+	queryLogMaxAge     = flag.Int("queryLogMaxAge", 0, "Rotate the query log file after this many hours, defaults to 0 (never)")
+	queryLogCompress   = flag.Bool("queryLogCompress", false, "Compress rotated query log files with gzip, defaults to false")
+	queryLogKeep       = flag.Int("queryLogKeep", 0, "The number of rotated query log files to keep, defaults to 0 (all of them)")
+	logLevel           = flag.String("logLevel", "info", "The lowest level of message that is logged: \"debug\", \"info\", \"warn\", or \"error\", defaults to \"info\"")
+	logFormat          = flag.String("logFormat", "text", "How log messages are written: \"text\" or \"json\", defaults to \"text\"")
+	allowPartial       = flag.Bool("allowPartial", false, "Keep running when some (but not all) of the listeners cannot be started, defaults to false")
+	adminAddress       = flag.String("admin", "", "The address (host:port) for the admin HTTP server that serves /metrics, /healthz, and /readyz, defaults to none (off)")
+	adminToken         = flag.String("adminToken", "", "The bearer token for the records API on the admin server, the API is off without one")
+	recordsFile        = flag.String("recordsFile", "", "A file that registered records and their leases are kept in so that they outlast a restart, defaults to none (kept in memory)")
+	recordsImport      = flag.String("recordsImport", "", "A JSON snapshot of records that is imported when the server starts, defaults to none")
+	dnstapSocket       = flag.String("dnstapSocket", "", "The unix socket of a dnstap collector that every question and response is written to, defaults to none")
+	dnstapFile         = flag.String("dnstapFile", "", "A file that every question and response is written to as dnstap messages, defaults to none")
+	dnstapIdentity     = flag.String("dnstapIdentity", "", "The identity written in each dnstap message, defaults to the host name")
+	chaosOff           = flag.Bool("chaosOff", false, "Treat CHAOS class questions (version.bind, id.server, stats.gyip, ...) like any other name, defaults to false")
+	config             = flag.String("config", "", "Path to a configuration file (.yaml, .yml, .toml, or .json). Options given on the command line override the file.")
 )
 
-// reverses the IP array
-func reverse(ips []net.IP) {
-	for i, j := 0, len(ips)-1; i < j; i, j = i+1, j-1 {
-		ips[i], ips[j] = ips[j], ips[i]
+func init() {
+	flag.Var(&listenSpecs, "listen", "Listener definitions separated by spaces, used instead of host, port, tcpOff, and udpOff. Each is transport[+transport]://host[:port][/domain[,domain]] (Ex: \"--listen 'udp+tcp://10.0.0.1:53 udp://127.0.0.1:8053/gyip.io dot://0.0.0.0:853'\")")
+}
+
+// reverses the token array
+func reverse(tokens []ipToken) {
+	for i, j := 0, len(tokens)-1; i < j; i, j = i+1, j-1 {
+		tokens[i], tokens[j] = tokens[j], tokens[i]
 	}
 }
 
@@ -54,8 +102,33 @@ func reverse(ips []net.IP) {
 // one way to confuse the parser is to do something like:
 // 10.27.14.34.45.337.0.1 which will end up with one address: [27.14.34.45] which isn't the intent since 337 is probably a mistake
 func parseIPs(addressString string) []net.IP {
-	// responses
 	var responses []net.IP
+	for _, token := range tokenizeIPs(addressString) {
+		if token.ip != nil {
+			responses = append(responses, token.ip)
+		}
+	}
+	return responses
+}
+
+// a part of a name, either an address or the text between addresses
+type ipToken struct {
+	text string
+	// nil when the text is not an address
+	ip net.IP
+}
+
+// splits the name into the addresses that parseIPs finds and the text around them that it skips, in order
+func tokenizeIPs(addressString string) []ipToken {
+	// tokens, found right to left
+	var tokens []ipToken
+	// the start of the last address that was found, the text after it has been tokenized
+	end := len(addressString)
+	skipped := func(text string) {
+		if text = strings.Trim(text, "."); text != "" {
+			tokens = append(tokens, ipToken{text: text})
+		}
+	}
 
 	// start with the left and right comparison positions
 	leftIndex := len(addressString) - 1
@@ -66,13 +139,16 @@ func parseIPs(addressString string) []net.IP {
 		checkString := addressString[leftIndex:rightIndex]
 		checkIP := net.ParseIP(checkString)
 		if checkIP != nil {
-			responses = append(responses, checkIP)
+			skipped(addressString[rightIndex:end])
+			tokens = append(tokens, ipToken{text: checkString, ip: checkIP})
+			end = leftIndex
 			rightIndex = leftIndex - 1
 			leftIndex = rightIndex - 1
 		} else {
 			// if the string wasn't parsed into an IP and there is no way we can adjust/jump our indexes
 			// then we need to stop. (fixes a loop when parsing the confusing string from the comment above: '10.27.14.34.45.337.0.1')
-			if strings.LastIndex(addressString[0:rightIndex - 1], ".") < 0 && strings.LastIndex(addressString[0:leftIndex-1], ":") < 0 {
+			// (a left index at the start of the string has nothing to its left, ex: 'a.10.0.0.1')
+			if strings.LastIndex(addressString[0:rightIndex-1], ".") < 0 && (leftIndex < 1 || strings.LastIndex(addressString[0:leftIndex-1], ":") < 0) {
 				break
 			}
 			// if we are already at 0, stop
@@ -95,67 +171,31 @@ func parseIPs(addressString string) []net.IP {
 		}
 	}
 
+	skipped(addressString[0:end])
+
 	// since we worked right to left we need to reverse the order before responding
 	// so that it maintains the left to right order we expect
-	if len(responses) > 0 {
-		reverse(responses)
-	}
+	reverse(tokens)
 
-	return responses
+	return tokens
 }
 
 // adapts the dns question to a response. this method is the bare minimum and allows a unit-testable
-// point within the dns "resolution" pipe
-func frameResponse(ip net.IP, questionType uint16, questionName string, currentQuestionDomain string) []dns.RR {
+// point within the dns "resolution" pipe. an error is returned when the domain refuses to answer.
+func frameResponse(ip net.IP, questionType uint16, questionName string, currentQuestionDomain string) ([]dns.RR, error) {
 	var (
 		records []dns.RR
 		ipV6    net.IP
 		ipV4    net.IP
 	)
 
-	// guards test cases
-	if "" == questionName || strings.LastIndex(questionName, currentQuestionDomain) < 0 {
-		return nil
-	}
-
-	// parse off the end domain and trailing dot
-	remainder := questionName[0 : len(questionName)-len(currentQuestionDomain)-1]
-
-	// ttl for response
-	var ttl uint32
-	// ips is am empty array
-	var ips []net.IP
-
-	// check for echo/reflect request
-	if "echo" == strings.ToLower(remainder) || "reflect" == strings.ToLower(remainder) {
-		ips = []net.IP{ip}
-	} else {
-		// check for command
-		var cmd command.Command = command.Noop{}
-		lastDotIndex := strings.LastIndex(remainder, ".")
-		if lastDotIndex > -1 {
-			potentialCommand := strings.ToUpper(remainder[lastDotIndex+1 : len(remainder)])
-			cmd = command.New(potentialCommand)
-			if cmd.Type() != command.NOOP {
-				remainder = remainder[0:lastDotIndex]
-			}
-		}
-
-		// get list of IPs
-		ips = parseIPs(remainder)
-
-		// if no ips are available then no domain is found
-		if len(ips) < 1 {
-			return nil
-		}
-
-		// use transform from found command and set the
-		// ttl based on the transformation
-		ips, ttl = cmd.Execute(ips)
+	resolved, err := resolveName(ip, questionName, currentQuestionDomain)
+	if err != nil || resolved == nil {
+		return nil, err
 	}
 
 	// for each IP create a response record
-	for _, ip := range ips {
+	for _, ip := range resolved.ips {
 		// set values based on presence of ipv4/ipv6
 		if ip != nil {
 			ipV4 = ip.To4()
@@ -168,63 +208,228 @@ func frameResponse(ip net.IP, questionType uint16, questionName string, currentQ
 		// create a record for the given response
 		if questionType == dns.TypeA && ipV4 != nil {
 			rr = &dns.A{
-				Hdr: dns.RR_Header{Name: questionName, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				Hdr: dns.RR_Header{Name: questionName, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: resolved.ttl},
 				A:   ipV4,
 			}
 			records = append(records, rr)
 		}
 
-		if questionType == dns.TypeAAAA && ipV6 != nil {
+		// registered ipv4 addresses only answer A questions
+		if questionType == dns.TypeAAAA && ipV6 != nil && !(resolved.registered && ipV4 != nil) {
 			rr = &dns.AAAA{
-				Hdr:  dns.RR_Header{Name: questionName, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+				Hdr:  dns.RR_Header{Name: questionName, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: resolved.ttl},
 				AAAA: ipV6,
 			}
 			records = append(records, rr)
 		}
 	}
 
-	return records
+	return records, nil
 }
 
-// encapsulates log output in the event that we want to do something else with it (or develop logic to silence it)
-func logQuestion(ip net.IP, currentQuestionDomain string, qName string, qtype string) {
-	fmt.Printf("[%s] ==> (%s): %s (%s)\n", ip, currentQuestionDomain, qName, qtype)	
+// how a question name was resolved to the addresses that answer it, kept so that it can be explained
+type resolution struct {
+	// the name was registered through the records api, and when its lease ends (zero without one)
+	registered bool
+	expires    time.Time
+	// the keyword (echo, reflect) in the name, empty when there isn't one
+	keyword string
+	// the command that was applied, nil for keywords
+	cmd command.Command
+	// how the name (without the domain and command) was split into addresses and the text around them
+	tokens []ipToken
+	// the addresses that were found but left out and why
+	skipped []skippedIP
+	// the addresses that answer the question and the ttl they are given
+	ips []net.IP
+	ttl uint32
+	// why the question was refused, or why the keyword or command was ignored
+	refused string
+	ignored string
 }
 
-// takes dns-level information and does some work to adapt it to a framed question that can be "resolved"
-func respondToQuestion(w dns.ResponseWriter, request *dns.Msg, message *dns.Msg, q dns.Question) {
-	questionName := q.Name
+// an address that was left out of the answer
+type skippedIP struct {
+	ip     net.IP
+	reason string
+}
 
-	currentQuestionDomain := ""
-	// find current domain
-	for _, servedDomain := range servingDomains {
-		if strings.HasSuffix(questionName, servedDomain) {
-			currentQuestionDomain = servedDomain
-			break
+// resolves the question name to the addresses that answer it. nil is returned for names that are not in
+// the domain and errRefused when the domain refuses to answer (along with why).
+func resolveName(ip net.IP, questionName string, currentQuestionDomain string) (*resolution, error) {
+	// the name is parsed in lower case, the domain and commands are matched without regard to case
+	questionName = strings.ToLower(questionName)
+	currentQuestionDomain = strings.ToLower(currentQuestionDomain)
+
+	// guards test cases
+	if "" == questionName || strings.LastIndex(questionName, currentQuestionDomain) < 0 || len(questionName) <= len(currentQuestionDomain) {
+		return nil, nil
+	}
+
+	// options for the domain the question is in
+	domainConfig := findDomain(currentQuestionDomain)
+
+	// parse off the end domain and trailing dot
+	remainder := questionName[0 : len(questionName)-len(currentQuestionDomain)-1]
+
+	resolved := &resolution{}
+
+	// a registered record is answered before anything else in the name
+	if record, found := recordStore.Get(questionName); found {
+		resolved.registered = true
+		resolved.ips = append(append(resolved.ips, record.A...), record.AAAA...)
+		resolved.ttl = record.TTL
+		if domainConfig.TTL > 0 && domainConfig.TTL < resolved.ttl {
+			resolved.ttl = domainConfig.TTL
+		}
+		// the answer isn't cached past the end of the lease
+		if !record.Expires.IsZero() {
+			resolved.expires = record.Expires
+			if remaining := uint32(time.Until(record.Expires) / time.Second); remaining < resolved.ttl {
+				resolved.ttl = remaining
+			}
 		}
+		return resolved, nil
 	}
 
-	// get ip
-	var ip net.IP
-	if cip, ok := w.RemoteAddr().(*net.UDPAddr); ok {
-		ip = cip.IP
+	// check for echo/reflect request
+	isKeyword := "echo" == strings.ToLower(remainder) || "reflect" == strings.ToLower(remainder)
+	if isKeyword && !domainConfig.allowsKeyword(remainder) {
+		if domainConfig.refuses() {
+			resolved.refused = fmt.Sprintf("the domain does not honor the keyword %s", strings.ToLower(remainder))
+			return resolved, errRefused
+		}
+		// without the keyword it is just a name with no ips
+		resolved.ignored = fmt.Sprintf("the domain does not honor the keyword %s", strings.ToLower(remainder))
+		return resolved, nil
 	}
-	if cip, ok := w.RemoteAddr().(*net.TCPAddr); ok {
-		ip = cip.IP
+
+	if isKeyword {
+		resolved.keyword = strings.ToLower(remainder)
+		if ip != nil {
+			resolved.ips = []net.IP{ip}
+		}
+		return resolved, nil
 	}
 
-	// parse log of current question
-	qtype := ""
-	if q.Qtype == dns.TypeA {
-		qtype = "A"
-	} else {
-		qtype = "AAAA"
+	// check for command
+	cmd, withoutCommand, err := splitCommand(remainder, domainConfig)
+	if err != nil {
+		resolved.refused = fmt.Sprintf("the domain does not honor the command %s", lastLabel(remainder))
+		return resolved, err
+	}
+	if cmd.Type() == command.NOOP && withoutCommand == remainder && command.New(strings.ToUpper(lastLabel(remainder))).Type() != command.NOOP {
+		resolved.ignored = fmt.Sprintf("the domain does not honor the command %s, it is part of the name", lastLabel(remainder))
 	}
+	resolved.cmd = cmd
+	remainder = withoutCommand
 
-	// encapsulate log output
-	logQuestion(ip, currentQuestionDomain, q.Name, qtype)
+	// get list of IPs, leaving out any that use an encoding or address the domain does not allow
+	var ips []net.IP
+	resolved.tokens = tokenizeIPs(remainder)
+	for _, token := range resolved.tokens {
+		if token.ip == nil {
+			continue
+		}
+		reason := ""
+		if !domainConfig.allowsEncoding(token.ip) {
+			reason = "the domain does not honor its encoding"
+		} else if !domainConfig.allowsAnswer(token.ip) {
+			reason = "the domain does not give it in answers"
+		}
+		if reason != "" {
+			resolved.skipped = append(resolved.skipped, skippedIP{ip: token.ip, reason: reason})
+			if domainConfig.refuses() {
+				resolved.refused = fmt.Sprintf("%s: %s", token.ip, reason)
+				return resolved, errRefused
+			}
+			continue
+		}
+		ips = append(ips, token.ip)
+	}
 
-	response := frameResponse(ip, q.Qtype, questionName, currentQuestionDomain)
+	// if no ips are available then no domain is found
+	if len(ips) < 1 {
+		return resolved, nil
+	}
+
+	// use transform from found command and set the
+	// ttl based on the transformation
+	resolved.ips, resolved.ttl = cmd.Execute(ips)
+
+	// the domain can shorten the ttl of every answer
+	if domainConfig.TTL > 0 && domainConfig.TTL < resolved.ttl {
+		resolved.ttl = domainConfig.TTL
+	}
+
+	return resolved, nil
+}
+
+// the last label of a name (the whole name when it has only one)
+func lastLabel(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// splits the command off of the end of the remainder of a question name (the part before the domain). commands
+// that the domain does not allow are left as part of the name, or refuse the question if the domain refuses.
+func splitCommand(remainder string, domainConfig *DomainConfig) (command.Command, string, error) {
+	lastDotIndex := strings.LastIndex(remainder, ".")
+	if lastDotIndex < 0 {
+		return command.Noop{}, remainder, nil
+	}
+	cmd := command.New(strings.ToUpper(remainder[lastDotIndex+1:]))
+	if !domainConfig.allowsCommand(cmd.Type()) {
+		if domainConfig.refuses() {
+			return nil, remainder, errRefused
+		}
+		return command.Noop{}, remainder, nil
+	}
+	if cmd.Type() != command.NOOP {
+		remainder = remainder[0:lastDotIndex]
+	}
+	return cmd, remainder, nil
+}
+
+// the name of the command (or keyword) that is applied to the question, "record" for registered names,
+// empty when none can be
+func appliedCommand(questionName string) string {
+	currentQuestionDomain := domainOf(questionName)
+	if currentQuestionDomain == "" || len(questionName) <= len(currentQuestionDomain) {
+		return ""
+	}
+	if _, found := recordStore.Get(questionName); found {
+		return "record"
+	}
+	remainder := questionName[0 : len(questionName)-len(currentQuestionDomain)-1]
+	domainConfig := findDomain(currentQuestionDomain)
+	if keyword := strings.ToLower(remainder); keyword == "echo" || keyword == "reflect" {
+		if domainConfig.allowsKeyword(remainder) {
+			return keyword
+		}
+		return ""
+	}
+	cmd, _, err := splitCommand(remainder, domainConfig)
+	if err != nil {
+		return ""
+	}
+	return cmd.Type().String()
+}
+
+// takes dns-level information and does some work to adapt it to a framed question that can be "resolved"
+func respondToQuestion(w dns.ResponseWriter, request *dns.Msg, message *dns.Msg, q dns.Question) {
+	questionName := q.Name
+
+	// find current domain
+	currentQuestionDomain := domainOf(questionName)
+
+	// get ip
+	ip := remoteIP(w.RemoteAddr())
+
+	response, err := frameResponse(ip, q.Qtype, questionName, currentQuestionDomain)
+	if err == errRefused {
+		message.Rcode = dns.RcodeRefused
+		return
+	}
 	if response != nil && len(response) > 0 {
 		for _, rr := range response {
 			message.Answer = append(message.Answer, rr)
@@ -237,19 +442,30 @@ func handleQuestions(w dns.ResponseWriter, r *dns.Msg) {
 	// setup outbound message
 	m := new(dns.Msg)
 	m.SetReply(r)
-	m.Compress = *compress
+	m.Compress = compressReplies
 	m.Authoritative = true
 
+	// the domain of each question can limit the clients that ask about it
+	for _, q := range r.Question {
+		if !findDomain(domainOf(q.Name)).Clients.admit(w, r) {
+			return
+		}
+	}
+
 	// handle _each_ question
 	for _, q := range m.Question {
 		// only "answer" if question is A or AAAA
 		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
 			respondToQuestion(w, r, m, q)
 		}
+		// a TXT question for explain.<name> is answered with why the name is answered the way it is
+		if q.Qtype == dns.TypeTXT {
+			explainQuestion(w, m, q)
+		}
 	}
 
-	// set return code to NXDOMAIN if no answers are found
-	if len(m.Answer) < 1 {
+	// set return code to NXDOMAIN if no answers are found (and the question wasn't refused)
+	if len(m.Answer) < 1 && m.Rcode == dns.RcodeSuccess {
 		m.Rcode = dns.RcodeNameError
 	}
 
@@ -257,138 +473,284 @@ func handleQuestions(w dns.ResponseWriter, r *dns.Msg) {
 	w.WriteMsg(m)
 }
 
-func serve(netType string, host string) {
-	addr := host + ":" + *port
-	fmt.Printf("Starting %s server on address: %s ...\n", netType, addr)
-	server := &dns.Server{Addr: addr, Net: netType, TsigSecret: nil}
-	if err := server.ListenAndServe(); err != nil {
-		fmt.Printf("Failed to setup the %s server: %s\n", netType, err.Error())
+// for all other domains just return a not zone response
+func handleNotZone(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Compress = compressReplies
+
+	// just say that the response code is that the question isn't in the zone
+	m.Rcode = dns.RcodeNotZone
+
+	// write back message
+	w.WriteMsg(m)
+}
+
+// sets the function being used to handle the dns questions for each of the given domains (or
+// all of the served domains when none are given), every other domain is not in the zone
+func domainMux(domains []string) *dns.ServeMux {
+	mux := dns.NewServeMux()
+	for _, servingDomain := range servingDomains {
+		if len(domains) < 1 || containsString(domains, servingDomain.Name) {
+			mux.HandleFunc(servingDomain.Name, handleQuestions)
+		}
 	}
+	mux.HandleFunc(".", handleNotZone)
+	return mux
+}
+
+// finds the served domain that the question name is in
+func domainOf(questionName string) string {
+	// names are matched without regard to case, the same as the dns mux that routes them here
+	questionName = strings.ToLower(questionName)
+	for _, servedDomain := range servingDomains {
+		// the domain has to end at a label so that names like notgyip.io. are not in gyip.io.
+		if questionName == servedDomain.Name || strings.HasSuffix(questionName, "."+servedDomain.Name) {
+			return servedDomain.Name
+		}
+	}
+	return ""
+}
+
+// finds the options for the served domain with the given name, names that are
+// not being served get the default options (and the default answer addresses)
+func findDomain(name string) *DomainConfig {
+	name = dnsName(name)
+	for _, servedDomain := range servingDomains {
+		if servedDomain.Name == name {
+			return servedDomain
+		}
+	}
+	return &DomainConfig{Name: name, answers: defaultAnswers}
 }
 
 func main() {
 	// figure out full version string
 	if "" != GitHash {
 		LongVersion = Version + "-git" + GitHash
-	}	
+	}
 
 	// parse options
 	flag.Usage = func() {
 		fmt.Printf("[gyip] - %s\n", LongVersion)
 		flag.PrintDefaults()
+		fmt.Printf("Each option can also be set with an environment variable (Ex: \"--tcpOff\" is \"%s\")\n", environmentName("tcpOff"))
 	}
 	flag.Parse()
 
-	if *domain == "" {
-		fmt.Print("At least one domain to host is required.\n")
+	// environment variables stand in for any flags that were not given
+	if err := applyEnvironment(flag.CommandLine); err != nil {
+		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
-	// split the input domain list
-	hostingDomains := splitHosts(*hosts)
-	// and do the same for the domains
-	servingDomains = splitDomains(*domain)
-
-	// check domain
-	if len(servingDomains) < 1 {
-		// if a bad domain then exit
-		fmt.Print("No valid domains were given. The server will not start.\n")
+	// start with the configuration file and then let the command line override it
+	cfg := defaultConfig()
+	if *config != "" {
+		loaded, err := loadConfig(*config)
+		if err != nil {
+			// each problem in the file is logged on its own
+			for _, problem := range strings.Split(err.Error(), "\n") {
+				logger.Error("The configuration file could not be loaded", logging.F("error", problem))
+			}
+			os.Exit(1)
+		}
+		cfg = loaded
+	}
+	if err := applyFlags(cfg); err != nil {
+		logger.Error(err.Error())
 		os.Exit(1)
 	}
-
-	// can't do anything if both tcp and udp are off
-	if *tcpOff && *udpOff {
-		fmt.Print("The options tcpOff and udpOff cannot both be set at the same time.\n")
+	if err := finishConfig(cfg); err != nil {
+		logger.Error("The server will not start", logging.F("error", err))
 		os.Exit(1)
 	}
 
-	// seed random number generator (does not need crypto-strength)
-	// just used for `rr` and `f` commands
-	rand.Seed(time.Now().UTC().UnixNano())
-
-	// set the function being used to handle the dns questions
-	for _, servingDomain := range servingDomains {
-		// log start of service
-		fmt.Printf("Providing service for domain: %s\n", servingDomain)
-		dns.HandleFunc(servingDomain, handleQuestions)
+	// use the configuration for the runtime options
+	for idx := range cfg.Domains {
+		servingDomains = append(servingDomains, &cfg.Domains[idx])
 	}
-	fmt.Print("(All other domains will receive NOZONE response)\n")
-
-	// for all other domains just return a not zone response
-	dns.HandleFunc(".", func(w dns.ResponseWriter, r *dns.Msg) {
-		m := new(dns.Msg)
-		m.SetReply(r)
-		m.Compress = *compress
-
-		// just say that the response code is that the question isn't in the zone
-		m.Rcode = dns.RcodeNotZone
-
-		// write back message
-		w.WriteMsg(m)
-	})
+	// the answers were checked when the configuration was finished
+	defaultAnswers, _ = buildAnswerList(cfg.Answers, AddressConfig{})
+	compressReplies = cfg.Compress
+	if err := configureLogging(cfg.Logging); err != nil {
+		logger.Error("The server will not start", logging.F("error", err))
+		os.Exit(1)
+	}
+	if err := configureDnstap(cfg.Dnstap); err != nil {
+		logger.Error("The server will not start", logging.F("error", err))
+		os.Exit(1)
+	}
+	ednsBufferSize = cfg.EDNS.BufferSize
+	chaosConfig = cfg.Chaos
+	recordsToken = cfg.Admin.Token
+	if err := configureRecords(cfg.Records); err != nil {
+		logger.Error("The server will not start", logging.F("error", err))
+		os.Exit(1)
+	}
+	// records registered with a lease are removed once it lapses
+	go watchLeases(leaseSweepInterval)
 
-	// based on options/config decide what protocols to provide
-	for _, host := range hostingDomains {
-		if strings.Index(host, ":") >= 0 {
-			host = "[" + host + "]"
+	// sockets from systemd or a parent process are served instead of the configured listeners
+	inherited, err := inheritedSockets()
+	if err != nil {
+		logger.Error("The server will not start", logging.F("error", err))
+		os.Exit(1)
+	}
+	listeners := cfg.Listeners
+	if len(inherited) > 0 {
+		listeners = []ListenerConfig{}
+		for _, s := range inherited {
+			listeners = append(listeners, inheritedListener(cfg.Listeners, s))
 		}
+	}
 
-		if !*tcpOff {
-			go serve("tcp", host)
-		}
-		if !*udpOff {
-			go serve("udp", host)
+	// encrypted listeners need a certificate before they can start
+	if needsTLS(listeners) {
+		loaded, err := loadTLS(cfg.TLS, cfg.Domains, listeners)
+		if err != nil {
+			logger.Error("The server will not start", logging.F("error", err))
+			os.Exit(1)
 		}
+		serverTLS = loaded
 	}
 
-	// wait for os signal
-	sig := make(chan os.Signal)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	s := <-sig
-	fmt.Printf("Signal (%s) received, stopping\n", s)
-}
+	// one limiter is shared by every listener so that a client can't get around it by changing listeners
+	if cfg.RateLimit.ResponsesPerSecond > 0 {
+		responseLimiter = rrl.New(rrl.Config{
+			ResponsesPerSecond: cfg.RateLimit.ResponsesPerSecond,
+			Window:             cfg.RateLimit.Window,
+			Slip:               cfg.RateLimit.Slip,
+			IPv4PrefixLength:   cfg.RateLimit.IPv4Prefix,
+			IPv6PrefixLength:   cfg.RateLimit.IPv6Prefix,
+		})
+		logger.Info("Limiting UDP responses for each client network", logging.F("perSecond", cfg.RateLimit.ResponsesPerSecond))
+		go reportRateLimits(responseLimiter, time.Minute)
+	}
 
-func splitHosts(hostInput string) []string {
-	outputHosts := []string{}
+	// seed random number generator (does not need crypto-strength)
+	// just used for `rr` and `f` commands
+	rand.Seed(time.Now().UTC().UnixNano())
 
-	hostStringSplit := strings.Split(hostInput, ",")
-	for _, hostToCheck := range hostStringSplit {
-		// if empty, continue
-		if hostToCheck == "" {
+	// log start of service
+	for _, servingDomain := range servingDomains {
+		logger.Info("Providing service for domain", logging.F("domain", servingDomain.Name))
+	}
+	logger.Info("All other domains will receive NOTZONE responses")
+
+	// based on options/config decide what protocols to provide, each host that can't be resolved
+	// and each server that can't be started is a failure
+	started := make(chan error)
+	requested := 0
+	failures := []error{}
+	for idx := range listeners {
+		listenerConfig := &listeners[idx]
+		// every listener checks the clients allowed everywhere and the clients allowed on the listener, the
+		// responses (including refusals) are fit to the client with edns and then rate limited
+		handler := drained(rateLimit(edns(clientFilter(chaos(domainMux(listenerConfig.Domains)), &cfg.Clients, &listenerConfig.Clients)), responseLimiter))
+		if len(inherited) > 0 {
+			logger.Info("Using inherited socket", logging.F("transport", inherited[idx].transport), logging.F("address", inherited[idx].addr()))
+			go serveSocket(inherited[idx], handler, started)
+			requested++
+			if err := <-started; err != nil {
+				failures = append(failures, err)
+			}
 			continue
 		}
 
-		// if ip, add to list
-		actualIP := net.ParseIP(hostToCheck)
-		if actualIP != nil {
-			// if someone uses the literal 0.0.0.0 just use that and stop parsing
-			if actualIP.String() == "0.0.0.0" {
-				return []string{"0.0.0.0"}
+		hosts, errs := resolveHosts(listenerConfig.Host)
+		requested += len(errs)
+		failures = append(failures, errs...)
+		bound := map[string][]*socket{}
+		for _, host := range hosts {
+			sockets, errs := startAddress(listenerConfig, host, handler)
+			requested += len(sockets) + len(errs)
+			failures = append(failures, errs...)
+			if len(sockets) > 0 {
+				bound[host] = sockets
 			}
+		}
 
-			outputHosts = append(outputHosts, actualIP.String())
-			continue
+		// the addresses of interfaces and host names can change while the server is running
+		if hostsChange(listenerConfig.Host) {
+			go watchHosts(listenerConfig, handler, bound, hostCheckInterval)
 		}
+	}
 
-		// if domain attempt resolution and, add to list
-		if checkDomain(hostToCheck) {
-			resolvedIPs, err := net.LookupIP(hostToCheck)
-			if err == nil {
-				for _, rIP := range resolvedIPs {
-					outputHosts = append(outputHosts, rIP.String())
+	// any listener that fails stops the server unless partial failure is allowed
+	for _, err := range failures {
+		logger.Error("Listener failed", logging.F("error", err))
+	}
+	failed := len(failures)
+	if failed == requested {
+		logger.Error("The server will not start: none of the listeners could be started")
+		os.Exit(1)
+	}
+	if failed > 0 && !cfg.AllowPartial {
+		logger.Error("The server will not start: some of the listeners could not be started (use --allowPartial to keep running without them)", logging.F("failed", failed), logging.F("listeners", requested))
+		os.Exit(1)
+	}
+	if failed > 0 {
+		logger.Warn("Running without some of the listeners", logging.F("failed", failed), logging.F("listeners", requested))
+	}
+	markStarted(failed)
+
+	// the admin server can only be bound once a process that handed its sockets over has stopped
+	if cfg.Admin.Address != "" {
+		if handedOff() {
+			go func() {
+				listener, err := listenAdmin(cfg.Admin.Address, time.Duration(cfg.Shutdown.GracePeriod)*time.Second+adminHandoffWait)
+				if err != nil {
+					logger.Error("The admin server could not be started", logging.F("error", err))
+					return
 				}
+				serveAdmin(listener)
+			}()
+		} else {
+			listener, err := listenAdmin(cfg.Admin.Address, 0)
+			if err != nil {
+				logger.Error("The server will not start: the admin server could not be started", logging.F("error", err))
+				os.Exit(1)
 			}
+			go serveAdmin(listener)
 		}
 	}
 
-	// if no hosts found just bind to everything (no specified host)
-	// shouldn't happen but it could in theory if hosts are specified
-	// but none of them are valid
-	if len(outputHosts) < 1 {
-		return []string{"0.0.0.0"}
+	// a process that was given sockets by another copy of gyip lets it stop now
+	finishHandoff()
+
+	// wait for os signal, SIGUSR2 hands the sockets over to a new process (to upgrade without downtime)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, handoffSignals...)...)
+	s := <-sig
+	for isHandoffSignal(s) {
+		if err := handoff(); err != nil {
+			logger.Error("The sockets could not be handed over", logging.F("error", err))
+		}
+		s = <-sig
 	}
+	logger.Info("Signal received, stopping", logging.F("signal", s))
+
+	// let the questions that are being answered finish
+	err = shutdown(time.Duration(cfg.Shutdown.GracePeriod) * time.Second)
+	closeDnstap()
+	closeRecords()
+	closeQueryLog()
+	if err != nil {
+		logger.Error("The server did not stop cleanly", logging.F("error", err))
+		os.Exit(1)
+	}
+	logger.Info("Stopped")
+}
 
-	return outputHosts
+// returns true if the signal asks for the sockets to be handed over
+func isHandoffSignal(s os.Signal) bool {
+	for _, handoffSignal := range handoffSignals {
+		if s == handoffSignal {
+			return true
+		}
+	}
+	return false
 }
 
 func splitDomains(domainInput string) []string {
@@ -411,7 +773,7 @@ func splitDomains(domainInput string) []string {
 		if checkDomain(domainToCheck) {
 			outputDomains = append(outputDomains, domainToCheck)
 		} else {
-			fmt.Printf("The domain \"%s\" is not a valid domain and cannot be served\n", domainToCheck)
+			logger.Warn("The domain is not a valid domain and cannot be served", logging.F("domain", domainToCheck))
 		}
 	}
 
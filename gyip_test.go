@@ -8,6 +8,35 @@ import (
 	"github.com/miekg/dns"
 )
 
+// records what is written back so that handlers can be tested without a server
+type testWriter struct {
+	remote  net.Addr
+	written *dns.Msg
+	closed  bool
+}
+
+func (w *testWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8053}
+}
+func (w *testWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+func (w *testWriter) WriteMsg(m *dns.Msg) error {
+	w.written = m
+	return nil
+}
+func (w *testWriter) Write(b []byte) (int, error) {
+	w.written = new(dns.Msg)
+	return len(b), w.written.Unpack(b)
+}
+func (w *testWriter) Close() error {
+	w.closed = true
+	return nil
+}
+func (w *testWriter) TsigStatus() error   { return nil }
+func (w *testWriter) TsigTimersOnly(bool) {}
+func (w *testWriter) Hijack()             {}
+
 func TestDomainCheck(t *testing.T) {
 
 	data := []struct {
@@ -53,6 +82,8 @@ func TestIPResponses(t *testing.T) {
 		{nil, dns.TypeA, "gyip.io", ".gyip.io", []string{}},
 		{nil, dns.TypeA, "gyip.io", "*(&()()*#@&#$)(*#_+__)(@_(@()@>........904098......)).gyip.io", []string{}},
 		{nil, dns.TypeA, "gyip.io", "10.27.14.34.45.337.0.1.gyip.io", []string{"27.14.34.45"}}, //TODO: fix because it causes a loop
+		{nil, dns.TypeA, "gyip.io", "a.10.0.0.1.gyip.io", []string{"10.0.0.1"}},
+		{nil, dns.TypeA, "gyip.io", "x.y.gyip.io", []string{}},
 		// with a command but don't inspect command implementation
 		{nil, dns.TypeA, "gyip.io", "10.0.0.1.rr.gyip.io", []string{"10.0.0.1"}},
 		// IPV6
@@ -70,7 +101,7 @@ func TestIPResponses(t *testing.T) {
 	}
 
 	for _, item := range data {
-		records := frameResponse(item.source, item.dnsType, item.inputQuestion, item.questionDomain)
+		records, _ := frameResponse(item.source, item.dnsType, item.inputQuestion, item.questionDomain)
 		// face check to see if records have the expected length
 		if len(item.outputIPs) != len(records) {
 			t.Errorf("The query '%s' for domain '%s' did not return the expected number of records (returned %d, expected %d", item.inputQuestion, item.questionDomain, len(records), len(item.outputIPs))
@@ -135,22 +166,31 @@ func TestDomainSplit(t *testing.T) {
 	}
 }
 
-func TestHostSplit(t *testing.T) {
+func TestDomainMux(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}, {Name: "gyip.net."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
 	data := []struct {
-		input    string
-		expected []string
+		domains       []string
+		question      string
+		expectedRcode int
 	}{
-		{"", []string{"0.0.0.0"}},
-		{"10.0.0.1", []string{"10.0.0.1"}},
-		{"127.0.0.1,12.4.3.9,888.888.888.888,10.1.1.1", []string{"127.0.0.1", "12.4.3.9", "10.1.1.1"}},
-		{"127.0.0.1,12.4.3.9,888.888.888.888,10.1.1.1,0.0.0.0", []string{"0.0.0.0"}},
-		//{"localhost", []string{"::1", "127.0.0.1"}}, //<- todo: figure out how to make this work in travis
+		{nil, "10.0.0.1.gyip.io.", dns.RcodeSuccess},
+		{nil, "10.0.0.1.gyip.net.", dns.RcodeSuccess},
+		{[]string{"gyip.io."}, "10.0.0.1.gyip.io.", dns.RcodeSuccess},
+		{[]string{"gyip.io."}, "10.0.0.1.gyip.net.", dns.RcodeNotZone},
+		{[]string{"gyip.io."}, "10.0.0.1.gyip.com.", dns.RcodeNotZone},
 	}
 
 	for _, item := range data {
-		hostResult := splitHosts(item.input)
-		if !reflect.DeepEqual(item.expected, hostResult) {
-			t.Errorf("The host input '%s' was not properly split (was: %v, expected %v)", item.input, hostResult, item.expected)
+		writer := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}}
+		question := new(dns.Msg)
+		question.SetQuestion(item.question, dns.TypeA)
+		domainMux(item.domains).ServeDNS(writer, question)
+		if writer.written == nil || writer.written.Rcode != item.expectedRcode {
+			t.Errorf("The query '%s' on a listener for %v did not get the expected response code %d (was: %v)", item.question, item.domains, item.expectedRcode, writer.written)
 		}
 	}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the first label of the questions the server asks itself to check that it is ready, these questions
+// are answered like any other but are not logged or counted and are not checked against the allowed
+// clients. the label is random for each process so that clients can't use it to keep their own
+// questions out of the logs or to get past the client checks.
+var readinessLabel = newReadinessLabel()
+
+// how long the server waits for the answer to each question it asks itself
+const readinessTimeout = time.Second
+
+// set once the listeners have been started
+var listenersStarted int32
+
+// the number of listeners that could not be started or that failed while serving
+var listenerFailures int32
+
+// records that the listeners have been started and how many of them could not be
+func markStarted(failed int) {
+	atomic.AddInt32(&listenerFailures, int32(failed))
+	atomic.StoreInt32(&listenersStarted, 1)
+}
+
+// records a listener that failed while serving
+func markFailed() {
+	atomic.AddInt32(&listenerFailures, 1)
+}
+
+// a label that can't be guessed, empty (so that every question is logged) if there is no randomness
+func newReadinessLabel() string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return ""
+	}
+	return "_readyz-" + hex.EncodeToString(random)
+}
+
+// returns true if the question is one that the server asked itself
+func isReadinessQuestion(r *dns.Msg) bool {
+	return readinessLabel != "" && len(r.Question) > 0 && strings.HasPrefix(strings.ToLower(r.Question[0].Name), readinessLabel+".")
+}
+
+// the process is alive if it can answer
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// the server is ready once every listener is bound and each udp and tcp socket answers a question
+// through the same handlers as every other question. it is not ready again once it starts stopping.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	problems := readinessProblems()
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, problem := range problems {
+			fmt.Fprintln(w, problem)
+		}
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// the reasons that the server is not ready, none when it is
+func readinessProblems() []string {
+	if isStopping() {
+		return []string{"the server is stopping"}
+	}
+	if atomic.LoadInt32(&listenersStarted) == 0 {
+		return []string{"the listeners are still being started"}
+	}
+	problems := []string{}
+	if failures := atomic.LoadInt32(&listenerFailures); failures > 0 {
+		problems = append(problems, fmt.Sprintf("%d listeners could not be started or have failed", failures))
+	}
+	if len(servingDomains) < 1 {
+		return append(problems, "there are no served domains to ask about")
+	}
+
+	// each address is only asked once even when it has more than one socket
+	socketLock.Lock()
+	targets := map[string]*socket{}
+	for _, s := range runningSockets {
+		if s.transport == "udp" || s.transport == "tcp" {
+			targets[s.transport+" "+s.addr().String()] = s
+		}
+	}
+	socketLock.Unlock()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range targets {
+		wg.Add(1)
+		go func(s *socket) {
+			defer wg.Done()
+			if err := askSelf(s.transport, s.addr().String()); err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				problems = append(problems, fmt.Sprintf("the %s socket on %s did not answer: %s", s.transport, s.addr(), err))
+			}
+		}(s)
+	}
+	wg.Wait()
+	return problems
+}
+
+// asks the socket at the address a question in the first served domain. the question passes the client
+// checks and has no address in it, so the socket is answering once it says that the name doesn't exist.
+func askSelf(transport string, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	// sockets bound to every address are asked over loopback
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	question := new(dns.Msg)
+	question.SetQuestion(readinessLabel+"."+servingDomains[0].Name, dns.TypeA)
+	client := &dns.Client{Net: transport, Timeout: readinessTimeout}
+	reply, _, err := client.Exchange(question, net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	if reply.Rcode != dns.RcodeNameError {
+		return fmt.Errorf("the answer was %s instead of %s", dns.RcodeToString[reply.Rcode], dns.RcodeToString[dns.RcodeNameError])
+	}
+	return nil
+}
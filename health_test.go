@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// the status and body of a request to the admin server
+func adminRequest(path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	adminHandler().ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestHealthz(t *testing.T) {
+	if status, body := adminRequest("/healthz"); status != http.StatusOK || body != "ok\n" {
+		t.Errorf("The health check did not pass (status: %d, body: %s)", status, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		resetRunning()
+	}()
+
+	// questions are answered through the same handlers as the listeners
+	started := make(chan error)
+	handler := drained(edns(domainMux(nil)))
+	for _, transport := range []string{"udp", "tcp"} {
+		s, err := listen(transport, "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Could not bind a %s socket: %s", transport, err)
+		}
+		go serveSocket(s, handler, started)
+		if err := <-started; err != nil {
+			t.Fatalf("Could not serve the %s socket: %s", transport, err)
+		}
+		defer s.stop()
+	}
+
+	if status, body := adminRequest("/readyz"); status != http.StatusServiceUnavailable || !strings.Contains(body, "still being started") {
+		t.Errorf("The server should not be ready before the listeners have been started (status: %d, body: %s)", status, body)
+	}
+
+	markStarted(0)
+	if status, body := adminRequest("/readyz"); status != http.StatusOK {
+		t.Errorf("The server should be ready once its sockets answer (status: %d, body: %s)", status, body)
+	}
+
+	// a socket that is bound but never answers
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Could not bind a udp socket: %s", err)
+	}
+	defer conn.Close()
+	silent := &socket{transport: "udp", packetConn: conn}
+	addRunningSocket(silent)
+	if status, body := adminRequest("/readyz"); status != http.StatusServiceUnavailable || !strings.Contains(body, "did not answer") {
+		t.Errorf("The server should not be ready while a socket does not answer (status: %d, body: %s)", status, body)
+	}
+	removeRunningSocket(silent)
+
+	// a socket that answers without getting to the served domains
+	refusing, err := listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not bind a udp socket: %s", err)
+	}
+	go serveSocket(refusing, dns.HandlerFunc(handleNotZone), started)
+	if err := <-started; err != nil {
+		t.Fatalf("Could not serve the udp socket: %s", err)
+	}
+	if status, body := adminRequest("/readyz"); status != http.StatusServiceUnavailable || !strings.Contains(body, "NOTZONE") {
+		t.Errorf("The server should not be ready while a socket does not answer from the served domains (status: %d, body: %s)", status, body)
+	}
+	refusing.stop()
+
+	markFailed()
+	if status, body := adminRequest("/readyz"); status != http.StatusServiceUnavailable || !strings.Contains(body, "1 listeners") {
+		t.Errorf("The server should not be ready after a listener failed (status: %d, body: %s)", status, body)
+	}
+	atomic.StoreInt32(&listenerFailures, 0)
+
+	atomic.StoreInt32(&stopping, 1)
+	if status, body := adminRequest("/readyz"); status != http.StatusServiceUnavailable || !strings.Contains(body, "stopping") {
+		t.Errorf("The server should not be ready while it is stopping (status: %d, body: %s)", status, body)
+	}
+}
+
+func TestReadinessNotObserved(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	before := queryCounter.Value("gyip.io.", "A", "udp", "NXDOMAIN", "noop")
+	question := new(dns.Msg)
+	question.SetQuestion(readinessLabel+".gyip.io.", dns.TypeA)
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5353}}
+	observe(dns.HandlerFunc(handleQuestions), "udp").ServeDNS(w, question)
+
+	if w.written == nil {
+		t.Errorf("The readiness question was not answered")
+	}
+	if queryCounter.Value("gyip.io.", "A", "udp", "NXDOMAIN", "noop") != before {
+		t.Errorf("The readiness question should not have been counted")
+	}
+
+	// a client can't keep its questions out of the logs by guessing the label
+	before = queryCounter.Value("gyip.io.", "A", "udp", "NOERROR", "noop")
+	for _, label := range []string{"_readyz", "_readyz-00000000000000000000000000000000"} {
+		question.SetQuestion(label+".10.0.0.1.gyip.io.", dns.TypeA)
+		observe(dns.HandlerFunc(handleQuestions), "udp").ServeDNS(w, question)
+	}
+	if counted := queryCounter.Value("gyip.io.", "A", "udp", "NOERROR", "noop") - before; counted != 2 {
+		t.Errorf("Questions from clients that look like readiness questions should have been counted (was: %v)", counted)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/miekg/dns"
+)
+
+// how often the addresses of interfaces and host names are checked for changes
+const hostCheckInterval = 30 * time.Second
+
+// the address that binds every interface, when it is given none of the other hosts are used
+const allInterfaces = "0.0.0.0"
+
+// the addresses for a single host entry: an ip address, the name of a network interface, or a
+// host name. an interface without any addresses has no addresses without it being an error.
+func resolveHost(entry string) ([]string, error) {
+	if ip := net.ParseIP(entry); ip != nil {
+		return []string{ip.String()}, nil
+	}
+
+	if iface, err := net.InterfaceByName(entry); err == nil {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("the addresses of interface \"%s\" could not be found: %s", entry, err)
+		}
+		hosts := []string{}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			// link local addresses can only be bound with the interface as the zone
+			if ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipNet.IP.String()+"%"+iface.Name)
+			} else {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+		return hosts, nil
+	}
+
+	if !checkDomain(entry) {
+		return nil, fmt.Errorf("the host \"%s\" is not an address, an interface, or a host name", entry)
+	}
+	ips, err := net.LookupIP(entry)
+	if err != nil {
+		return nil, fmt.Errorf("the host \"%s\" could not be resolved: %s", entry, err)
+	}
+	hosts := []string{}
+	for _, ip := range ips {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts, nil
+}
+
+// the addresses to bind for a comma-separated list of hosts, no hosts binds every interface. each entry
+// that can't be resolved (or has no addresses) is an error, the addresses for the others are still returned.
+func resolveHosts(hostInput string) ([]string, []error) {
+	entries := splitList(hostInput)
+	if len(entries) < 1 {
+		return []string{allInterfaces}, nil
+	}
+
+	hosts := []string{}
+	errs := []error{}
+	for _, entry := range entries {
+		resolved, err := resolveHost(entry)
+		if err == nil && len(resolved) < 1 {
+			err = fmt.Errorf("the interface \"%s\" does not have any addresses", entry)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		hosts = append(hosts, resolved...)
+	}
+	return uniqueHosts(hosts), errs
+}
+
+// removes duplicate addresses, if every interface is bound none of the others are needed
+func uniqueHosts(hosts []string) []string {
+	unique := []string{}
+	for _, host := range hosts {
+		if host == allInterfaces {
+			return []string{allInterfaces}
+		}
+		if !containsString(unique, host) {
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+// returns true if the hosts have interfaces or host names in them, the addresses of which can change
+func hostsChange(hostInput string) bool {
+	changes := false
+	for _, entry := range splitList(hostInput) {
+		if entry == allInterfaces {
+			return false
+		}
+		if net.ParseIP(entry) == nil {
+			changes = true
+		}
+	}
+	return changes
+}
+
+// starts every transport (and each of its sockets) of the listener on the address. returns the sockets
+// that are being served and an error for each one that could not be started.
+func startAddress(listenerConfig *ListenerConfig, host string, handler dns.Handler) ([]*socket, []error) {
+	started := make(chan error)
+	sockets := []*socket{}
+	errs := []error{}
+	addr := net.JoinHostPort(host, strconv.Itoa(listenerConfig.Port))
+	// more than one socket for an address are shared with SO_REUSEPORT
+	shared := listenerConfig.Sockets > 1
+
+	for _, transport := range listenerConfig.Transports {
+		transport = strings.ToLower(transport)
+		for count := 0; count < listenerConfig.Sockets; count++ {
+			var s *socket
+			var err error
+			if shared {
+				logger.Info("Starting shared server", logging.F("transport", transport), logging.F("address", addr))
+				s, err = listenShared(transport, addr)
+			} else {
+				logger.Info("Starting server", logging.F("transport", transport), logging.F("address", addr))
+				s, err = listen(transport, addr)
+			}
+			if err != nil {
+				listenerErrors.Inc(transport)
+				errs = append(errs, fmt.Errorf("the %s server on %s could not be started: %s", transport, addr, err))
+				continue
+			}
+			go serveSocket(s, handler, started)
+			if err := <-started; err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			sockets = append(sockets, s)
+		}
+	}
+
+	return sockets, errs
+}
+
+// checks the addresses of the listener's interfaces and host names every interval. servers are started on new
+// addresses and stopped on addresses that have gone away. a host name that can't be resolved keeps its addresses.
+func watchHosts(listenerConfig *ListenerConfig, handler dns.Handler, bound map[string][]*socket, interval time.Duration) {
+	previous := map[string][]string{}
+	for _, entry := range splitList(listenerConfig.Host) {
+		previous[entry], _ = resolveHost(entry)
+	}
+
+	for range time.Tick(interval) {
+		if isStopping() {
+			return
+		}
+
+		hosts := []string{}
+		for _, entry := range splitList(listenerConfig.Host) {
+			resolved, err := resolveHost(entry)
+			if err != nil {
+				logger.Warn("Keeping the previous addresses for host", logging.F("host", entry), logging.F("error", err))
+				resolved = previous[entry]
+			}
+			previous[entry] = resolved
+			hosts = append(hosts, resolved...)
+		}
+		rebind(listenerConfig, handler, bound, uniqueHosts(hosts))
+	}
+}
+
+// stops the servers on the addresses that aren't in hosts and starts servers on the new ones
+func rebind(listenerConfig *ListenerConfig, handler dns.Handler, bound map[string][]*socket, hosts []string) {
+	removed := []string{}
+	for host := range bound {
+		if !containsString(hosts, host) {
+			removed = append(removed, host)
+		}
+	}
+	sort.Strings(removed)
+	for _, host := range removed {
+		logger.Info("The address has gone away, stopping its servers", logging.F("address", host))
+		for _, s := range bound[host] {
+			s.stop()
+		}
+		delete(bound, host)
+	}
+
+	for _, host := range hosts {
+		if _, found := bound[host]; found {
+			continue
+		}
+		logger.Info("Found new address", logging.F("address", host))
+		sockets, errs := startAddress(listenerConfig, host, handler)
+		for _, err := range errs {
+			logger.Error("Listener failed", logging.F("error", err))
+		}
+		// an address that couldn't be started is tried again the next time
+		if len(sockets) > 0 {
+			bound[host] = sockets
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolveHosts(t *testing.T) {
+	data := []struct {
+		input          string
+		expected       []string
+		expectedErrors int
+	}{
+		{"", []string{"0.0.0.0"}, 0},
+		{"10.0.0.1", []string{"10.0.0.1"}, 0},
+		{"10.0.0.1,10.0.0.1,::1", []string{"10.0.0.1", "::1"}, 0},
+		{"127.0.0.1,12.4.3.9,888.888.888.888,10.1.1.1", []string{"127.0.0.1", "12.4.3.9", "10.1.1.1"}, 1},
+		{"127.0.0.1,12.4.3.9,888.888.888.888,10.1.1.1,0.0.0.0", []string{"0.0.0.0"}, 1},
+		{"no-such-host.invalid", []string{}, 1},
+	}
+
+	for _, item := range data {
+		hosts, errs := resolveHosts(item.input)
+		if !reflect.DeepEqual(item.expected, hosts) || len(errs) != item.expectedErrors {
+			t.Errorf("The host input '%s' was not properly resolved (was: %v with errors %v, expected %v)", item.input, hosts, errs, item.expected)
+		}
+	}
+
+	// interfaces are given by name
+	loopback := ""
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback = iface.Name
+		}
+	}
+	if loopback == "" {
+		t.Skip("No loopback interface was found")
+	}
+	hosts, errs := resolveHosts(loopback)
+	if len(errs) > 0 || !containsString(hosts, "127.0.0.1") {
+		t.Errorf("The interface '%s' did not resolve to the loopback address (was: %v with errors %v)", loopback, hosts, errs)
+	}
+}
+
+func TestHostsChange(t *testing.T) {
+	data := []struct {
+		input    string
+		expected bool
+	}{
+		{"", false},
+		{"10.0.0.1,::1", false},
+		{"10.0.0.1,eth0", true},
+		{"gyip.io", true},
+		{"eth0,0.0.0.0", false},
+	}
+
+	for _, item := range data {
+		if changes := hostsChange(item.input); changes != item.expected {
+			t.Errorf("The host input '%s' did not give the expected result (was: %t, expected %t)", item.input, changes, item.expected)
+		}
+	}
+}
+
+func TestStartAddress(t *testing.T) {
+	defer resetRunning()
+
+	// a port that is already taken can't be bound
+	taken, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not take a port: %s", err)
+	}
+	defer taken.Close()
+	takenPort := taken.LocalAddr().(*net.UDPAddr).Port
+
+	sockets, errs := startAddress(&ListenerConfig{Port: takenPort, Transports: []string{"udp", "tcp"}, Sockets: 1}, "127.0.0.1", dns.DefaultServeMux)
+	if len(sockets) != 1 || sockets[0].transport != "tcp" || len(errs) != 1 {
+		t.Errorf("Only the tcp server should have started on a port that is taken for udp (was: %v with errors %v)", sockets, errs)
+	}
+
+	sockets, errs = startAddress(&ListenerConfig{Port: 0, Transports: []string{"UDP"}, Sockets: 2}, "127.0.0.1", dns.DefaultServeMux)
+	if len(sockets) != 2 || len(errs) != 0 {
+		t.Errorf("Both shared servers should have started (was: %v with errors %v)", sockets, errs)
+	}
+	shutdown(time.Second)
+}
+
+func TestRebind(t *testing.T) {
+	defer resetRunning()
+
+	listenerConfig := &ListenerConfig{Port: 0, Transports: []string{"udp"}, Sockets: 1}
+	bound := map[string][]*socket{}
+
+	rebind(listenerConfig, dns.DefaultServeMux, bound, []string{"127.0.0.1"})
+	if len(bound["127.0.0.1"]) != 1 {
+		t.Fatalf("A server was not started on the new address (was: %v)", bound)
+	}
+	first := bound["127.0.0.1"][0]
+
+	// nothing changes when the addresses stay the same
+	rebind(listenerConfig, dns.DefaultServeMux, bound, []string{"127.0.0.1"})
+	if len(bound) != 1 || bound["127.0.0.1"][0] != first {
+		t.Errorf("The server should not have been restarted (was: %v)", bound)
+	}
+
+	// the server is stopped when the address goes away
+	addr := first.addr().String()
+	rebind(listenerConfig, dns.DefaultServeMux, bound, []string{})
+	if len(bound) != 0 {
+		t.Errorf("The server should have been stopped (was: %v)", bound)
+	}
+	if len(runningSockets) != 0 {
+		t.Errorf("The stopped socket should not be handed over (was: %v)", runningSockets)
+	}
+	rebound, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Errorf("The address %s should be free after the server stopped: %s", addr, err)
+	} else {
+		rebound.Close()
+	}
+}
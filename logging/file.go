@@ -0,0 +1,195 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the time added to the name of a rotated file, it sorts in the order the files were rotated
+const rotatedTimeFormat = "2006-01-02T15-04-05.000"
+
+// the extension added to rotated files once they are compressed
+const compressedExtension = ".gz"
+
+// Rotation - when a log file is rotated and what is done with the rotated files
+type Rotation struct {
+	// the file is rotated before it grows past this many bytes, 0 never rotates it for its size
+	MaxSize int64
+	// the file is rotated once it has been open this long, 0 never rotates it for its age
+	MaxAge time.Duration
+	// rotated files are compressed with gzip
+	Compress bool
+	// the number of rotated files that are kept, the oldest are removed, 0 keeps all of them
+	Keep int
+}
+
+// File - a log file that is rotated, rotated files are renamed with the time they were rotated
+type File struct {
+	lock     sync.Mutex
+	path     string
+	rotation Rotation
+	file     *os.File
+	size     int64
+	opened   time.Time
+	// the time in the name of the last rotated file
+	rotated time.Time
+	// rotated files that are still being compressed
+	pending sync.WaitGroup
+	// the time used to decide when to rotate, replaced in tests
+	now func() time.Time
+}
+
+// OpenFile - opens (or creates) the log file at the path, new messages are added to the end
+func OpenFile(path string, rotation Rotation) (*File, error) {
+	f := &File{path: path, rotation: rotation, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// opens the file at the path and starts counting its age
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	return nil
+}
+
+// Write - adds the bytes to the file, rotating it first if they would make it too big or it is too old
+func (f *File) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooBig := f.rotation.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.rotation.MaxSize
+	tooOld := f.rotation.MaxAge > 0 && f.now().Sub(f.opened) >= f.rotation.MaxAge
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	written, err := f.file.Write(p)
+	f.size += int64(written)
+	return written, err
+}
+
+// renames the file with the current time and opens a new one in its place, the rotated file is
+// compressed (and the oldest rotated files are removed) without holding up the messages being written
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	// files rotated within the same millisecond still get their own names
+	stamp := f.now().UTC().Truncate(time.Millisecond)
+	if !stamp.After(f.rotated) {
+		stamp = f.rotated.Add(time.Millisecond)
+	}
+	f.rotated = stamp
+	rotated := f.path + "." + stamp.Format(rotatedTimeFormat)
+	if err := os.Rename(f.path, rotated); err != nil {
+		// keep writing to the file that couldn't be rotated
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.pending.Add(1)
+	go func() {
+		defer f.pending.Done()
+		if f.rotation.Compress {
+			compress(rotated)
+		}
+		f.prune()
+	}()
+	return nil
+}
+
+// replaces the file with a gzip compressed copy, the file is kept if it can't be compressed
+func compress(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(path+compressedExtension, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	compressor := gzip.NewWriter(target)
+	_, err = io.Copy(compressor, source)
+	if err == nil {
+		err = compressor.Close()
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressedExtension)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// the rotated copies of the file, oldest first
+func (f *File) rotatedFiles() []string {
+	matches, _ := filepath.Glob(f.path + ".*")
+	rotated := []string{}
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, f.path+"."), compressedExtension)
+		if _, err := time.Parse(rotatedTimeFormat, stamp); err == nil {
+			rotated = append(rotated, match)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated
+}
+
+// removes the oldest rotated files that are over the number that are kept
+func (f *File) prune() {
+	if f.rotation.Keep < 1 {
+		return
+	}
+	rotated := f.rotatedFiles()
+	for len(rotated) > f.rotation.Keep {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+// Close - closes the file once the rotated files have been compressed
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pending.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
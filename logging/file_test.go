@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a log file in a new temporary directory with a clock that the test moves forward
+func testFile(t *testing.T, rotation Rotation) (*File, string, *time.Time) {
+	dir, err := ioutil.TempDir("", "gyip-log")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	clock := time.Date(2018, 7, 1, 12, 30, 0, 0, time.UTC)
+	path := filepath.Join(dir, "queries.log")
+	f, err := OpenFile(path, rotation)
+	if err != nil {
+		t.Fatalf("Could not open the log file: %s", err)
+	}
+	f.now = func() time.Time {
+		return clock
+	}
+	f.opened = clock
+	return f, path, &clock
+}
+
+func TestRotateSize(t *testing.T) {
+	f, path, clock := testFile(t, Rotation{MaxSize: 20})
+	defer os.RemoveAll(filepath.Dir(path))
+
+	for _, line := range []string{"first line\n", "second line\n", "third line\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Errorf("Could not write to the log file: %s", err)
+		}
+		*clock = clock.Add(time.Second)
+	}
+	f.Close()
+
+	rotated := f.rotatedFiles()
+	if len(rotated) != 2 {
+		t.Fatalf("The log file should have been rotated twice (rotated: %v)", rotated)
+	}
+	if !strings.HasSuffix(rotated[0], ".2018-07-01T12-30-01.000") {
+		t.Errorf("The rotated file was not named with the time it was rotated (was: %s)", rotated[0])
+	}
+	for file, expected := range map[string]string{rotated[0]: "first line\n", rotated[1]: "second line\n", path: "third line\n"} {
+		contents, _ := ioutil.ReadFile(file)
+		if string(contents) != expected {
+			t.Errorf("The file %s has '%s', expected '%s'", file, contents, expected)
+		}
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	f, path, clock := testFile(t, Rotation{MaxAge: time.Hour})
+	defer os.RemoveAll(filepath.Dir(path))
+
+	f.Write([]byte("first line\n"))
+	*clock = clock.Add(59 * time.Minute)
+	f.Write([]byte("second line\n"))
+	if len(f.rotatedFiles()) != 0 {
+		t.Errorf("The log file should not have been rotated before it was an hour old")
+	}
+	*clock = clock.Add(time.Minute)
+	f.Write([]byte("third line\n"))
+	f.Close()
+
+	rotated := f.rotatedFiles()
+	if len(rotated) != 1 {
+		t.Fatalf("The log file should have been rotated once it was an hour old (rotated: %v)", rotated)
+	}
+	contents, _ := ioutil.ReadFile(rotated[0])
+	if string(contents) != "first line\nsecond line\n" {
+		t.Errorf("The rotated file has '%s'", contents)
+	}
+}
+
+func TestRotateCompressKeep(t *testing.T) {
+	f, path, clock := testFile(t, Rotation{MaxSize: 1, Compress: true, Keep: 2})
+	defer os.RemoveAll(filepath.Dir(path))
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		f.Write([]byte(line))
+		*clock = clock.Add(time.Second)
+		// let each compression finish so that the oldest files are the ones removed
+		f.pending.Wait()
+	}
+	f.Close()
+
+	rotated := f.rotatedFiles()
+	if len(rotated) != 2 {
+		t.Fatalf("Only two rotated files should have been kept (rotated: %v)", rotated)
+	}
+	for idx, expected := range []string{"three\n", "four\n"} {
+		if !strings.HasSuffix(rotated[idx], ".gz") {
+			t.Errorf("The rotated file %s was not compressed", rotated[idx])
+			continue
+		}
+		compressed, _ := os.Open(rotated[idx])
+		reader, err := gzip.NewReader(compressed)
+		if err != nil {
+			t.Errorf("The rotated file %s is not gzip compressed: %s", rotated[idx], err)
+			compressed.Close()
+			continue
+		}
+		contents, _ := ioutil.ReadAll(reader)
+		compressed.Close()
+		if string(contents) != expected {
+			t.Errorf("The rotated file %s has '%s', expected '%s'", rotated[idx], contents, expected)
+		}
+	}
+}
+
+func TestRotateSameTime(t *testing.T) {
+	f, path, _ := testFile(t, Rotation{MaxSize: 1})
+	defer os.RemoveAll(filepath.Dir(path))
+
+	// rotations at the same time don't replace each other
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		f.Write([]byte(line))
+	}
+	f.Close()
+	if rotated := f.rotatedFiles(); len(rotated) != 2 {
+		t.Errorf("The log file should have been rotated to two files (rotated: %v)", rotated)
+	}
+	if _, err := f.Write([]byte("closed\n")); err == nil {
+		t.Errorf("Writing to a closed file should fail")
+	}
+}
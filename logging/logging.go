@@ -0,0 +1,216 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level - how important a message is, messages below the level of a logger are not written
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+// Format - how each message is written
+type Format int
+
+const (
+	// key=value pairs after the time, level, and message
+	TEXT Format = iota
+	// one json object for each message
+	JSON
+)
+
+// the names of the levels and formats as they are given in options and written out
+var levelNames = map[Level]string{DEBUG: "debug", INFO: "info", WARN: "warn", ERROR: "error"}
+var formatNames = map[Format]string{TEXT: "text", JSON: "json"}
+
+// Field - a named value that is written with a message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F - shorthand for a field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger - writes messages at or above a level in a format, a nil logger writes nothing
+type Logger struct {
+	lock   sync.Mutex
+	out    io.Writer
+	level  Level
+	format Format
+	// the time for each message, replaced in tests
+	now func() time.Time
+}
+
+// New - a logger that writes messages at or above the level to out
+func New(out io.Writer, level Level, format Format) *Logger {
+	return &Logger{out: out, level: level, format: format, now: time.Now}
+}
+
+// String - the name of the level
+func (level Level) String() string {
+	if name, found := levelNames[level]; found {
+		return name
+	}
+	return "level(" + strconv.Itoa(int(level)) + ")"
+}
+
+// String - the name of the format
+func (format Format) String() string {
+	if name, found := formatNames[format]; found {
+		return name
+	}
+	return "format(" + strconv.Itoa(int(format)) + ")"
+}
+
+// ParseLevel - the level with the given name (any case), false if there isn't one
+func ParseLevel(name string) (Level, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, true
+		}
+	}
+	return INFO, false
+}
+
+// ParseFormat - the format with the given name (any case), false if there isn't one
+func ParseFormat(name string) (Format, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for format, formatName := range formatNames {
+		if formatName == name {
+			return format, true
+		}
+	}
+	return TEXT, false
+}
+
+// Enabled - true if messages at the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Log - writes the message and its fields if the level is enabled
+func (l *Logger) Log(level Level, message string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var line []byte
+	when := l.now().UTC().Format(time.RFC3339Nano)
+	if l.format == JSON {
+		line = jsonLine(when, level, message, fields)
+	} else {
+		line = textLine(when, level, message, fields)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.out.Write(line)
+}
+
+// Debug - logs the message at the debug level
+func (l *Logger) Debug(message string, fields ...Field) {
+	l.Log(DEBUG, message, fields...)
+}
+
+// Info - logs the message at the info level
+func (l *Logger) Info(message string, fields ...Field) {
+	l.Log(INFO, message, fields...)
+}
+
+// Warn - logs the message at the warn level
+func (l *Logger) Warn(message string, fields ...Field) {
+	l.Log(WARN, message, fields...)
+}
+
+// Error - logs the message at the error level
+func (l *Logger) Error(message string, fields ...Field) {
+	l.Log(ERROR, message, fields...)
+}
+
+// time level message key=value ..., values are quoted when they would otherwise be hard to split apart
+func textLine(when string, level Level, message string, fields []Field) []byte {
+	var b strings.Builder
+	b.WriteString(when)
+	b.WriteString(" ")
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteString(" ")
+	b.WriteString(message)
+	for _, field := range fields {
+		b.WriteString(" ")
+		b.WriteString(field.Key)
+		b.WriteString("=")
+		b.WriteString(textValue(field.Value))
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// the text form of a field value, lists are joined with commas
+func textValue(value interface{}) string {
+	var text string
+	switch typed := value.(type) {
+	case string:
+		text = typed
+	case []string:
+		text = strings.Join(typed, ",")
+	case error:
+		text = typed.Error()
+	case fmt.Stringer:
+		text = typed.String()
+	default:
+		text = fmt.Sprint(value)
+	}
+	if text == "" || strings.ContainsAny(text, " =\"\t\n") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+// {"time":...,"level":...,"msg":...,key:value,...} with the fields in the order they were given
+func jsonLine(when string, level Level, message string, fields []Field) []byte {
+	var b strings.Builder
+	b.WriteString(`{"time":`)
+	b.WriteString(jsonValue(when))
+	b.WriteString(`,"level":`)
+	b.WriteString(jsonValue(level.String()))
+	b.WriteString(`,"msg":`)
+	b.WriteString(jsonValue(message))
+	for _, field := range fields {
+		b.WriteString(",")
+		b.WriteString(jsonValue(field.Key))
+		b.WriteString(":")
+		b.WriteString(jsonValue(field.Value))
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// the json form of a field value, values that can't be encoded are written as their text
+func jsonValue(value interface{}) string {
+	switch typed := value.(type) {
+	case error:
+		value = typed.Error()
+	case fmt.Stringer:
+		value = typed.String()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return string(encoded)
+}
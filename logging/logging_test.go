@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// a logger that writes to a buffer at a fixed time
+func testLogger(level Level, format Format) (*Logger, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	logger := New(out, level, format)
+	logger.now = func() time.Time {
+		return time.Date(2018, 7, 1, 12, 30, 0, 0, time.UTC)
+	}
+	return logger, out
+}
+
+func TestParseLevel(t *testing.T) {
+	data := []struct {
+		name  string
+		level Level
+		valid bool
+	}{
+		{"debug", DEBUG, true},
+		{"INFO", INFO, true},
+		{" warn ", WARN, true},
+		{"error", ERROR, true},
+		{"verbose", INFO, false},
+		{"", INFO, false},
+	}
+
+	for _, item := range data {
+		level, valid := ParseLevel(item.name)
+		if valid != item.valid || level != item.level {
+			t.Errorf("The level '%s' was parsed as %s (valid: %t), expected %s (valid: %t)", item.name, level, valid, item.level, item.valid)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	data := []struct {
+		name   string
+		format Format
+		valid  bool
+	}{
+		{"text", TEXT, true},
+		{"JSON", JSON, true},
+		{"logfmt", TEXT, false},
+	}
+
+	for _, item := range data {
+		format, valid := ParseFormat(item.name)
+		if valid != item.valid || format != item.format {
+			t.Errorf("The format '%s' was parsed as %s (valid: %t), expected %s (valid: %t)", item.name, format, valid, item.format, item.valid)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	logger, out := testLogger(WARN, TEXT)
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	expected := "2018-07-01T12:30:00Z WARN warn\n2018-07-01T12:30:00Z ERROR error\n"
+	if out.String() != expected {
+		t.Errorf("Only the warn and error messages should have been written, got:\n%s", out.String())
+	}
+
+	// a nil logger writes nothing (and doesn't fail)
+	var off *Logger
+	off.Error("error")
+	if off.Enabled(ERROR) {
+		t.Errorf("A nil logger should not have any levels enabled")
+	}
+}
+
+func TestText(t *testing.T) {
+	data := []struct {
+		fields   []Field
+		expected string
+	}{
+		{[]Field{}, "2018-07-01T12:30:00Z INFO query\n"},
+		{[]Field{F("client", net.ParseIP("10.0.0.1")), F("qtype", "A")}, "2018-07-01T12:30:00Z INFO query client=10.0.0.1 qtype=A\n"},
+		{[]Field{F("answers", []string{"10.0.0.1", "10.0.0.2"})}, "2018-07-01T12:30:00Z INFO query answers=10.0.0.1,10.0.0.2\n"},
+		{[]Field{F("error", fmt.Errorf("address in use")), F("command", "")}, "2018-07-01T12:30:00Z INFO query error=\"address in use\" command=\"\"\n"},
+		{[]Field{F("latency", 1.5), F("dropped", true)}, "2018-07-01T12:30:00Z INFO query latency=1.5 dropped=true\n"},
+	}
+
+	for _, item := range data {
+		logger, out := testLogger(DEBUG, TEXT)
+		logger.Info("query", item.fields...)
+		if out.String() != item.expected {
+			t.Errorf("The fields %v were written as '%s', expected '%s'", item.fields, out.String(), item.expected)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	logger, out := testLogger(DEBUG, JSON)
+	logger.Info("query", F("client", net.ParseIP("::1")), F("answers", []string{"10.0.0.1"}), F("latency", 0.25), F("error", fmt.Errorf("refused")))
+
+	expected := `{"time":"2018-07-01T12:30:00Z","level":"info","msg":"query","client":"::1","answers":["10.0.0.1"],"latency":0.25,"error":"refused"}` + "\n"
+	if out.String() != expected {
+		t.Errorf("The message was written as '%s', expected '%s'", out.String(), expected)
+	}
+
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Errorf("The message is not valid json: %s", err)
+	}
+}
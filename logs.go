@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/miekg/dns"
+)
+
+// the names that can be given for the log level and format
+var (
+	logLevels  = []string{"debug", "info", "warn", "error"}
+	logFormats = []string{"text", "json"}
+)
+
+// the operational log and the query log (from the combined configuration file and command line), the query
+// log is nil when queries are not logged. messages before the configuration is loaded use the defaults.
+var (
+	logger      = logging.New(os.Stdout, logging.INFO, logging.TEXT)
+	queryLogger = logging.New(os.Stdout, logging.INFO, logging.TEXT)
+)
+
+// the file the query log is written to, nil when it is written to the output
+var queryFile *logging.File
+
+// every nth question is logged, the count is of every question on every listener
+var (
+	querySample uint64 = 1
+	queryCount  uint64
+)
+
+// LoggingConfig - controls what is written to the output
+type LoggingConfig struct {
+	// log every question along with how it was answered
+	Queries bool `json:"queries" yaml:"queries" toml:"queries"`
+	// the lowest level of operational message that is written: "debug", "info", "warn", or "error"
+	Level string `json:"level" yaml:"level" toml:"level"`
+	// how each message is written: "text" or "json"
+	Format string `json:"format" yaml:"format" toml:"format"`
+	// only one in this many questions is logged, 0 and 1 log every question
+	QuerySample int `json:"querySample" yaml:"querySample" toml:"querySample"`
+	// writes the query log to a file instead of the output
+	QueryFile QueryFileConfig `json:"queryFile" yaml:"queryFile" toml:"queryFile"`
+}
+
+// QueryFileConfig - the file that the query log is written to and when it is rotated
+type QueryFileConfig struct {
+	// the path of the file, the query log is written to the output when there isn't one
+	Path string `json:"path" yaml:"path" toml:"path"`
+	// the file is rotated before it grows past this many megabytes, 0 never rotates it for its size
+	MaxSize int `json:"maxSize" yaml:"maxSize" toml:"maxSize"`
+	// the file is rotated once it has been written to for this many hours, 0 never rotates it for its age
+	MaxAge int `json:"maxAge" yaml:"maxAge" toml:"maxAge"`
+	// rotated files are compressed with gzip
+	Compress bool `json:"compress" yaml:"compress" toml:"compress"`
+	// the number of rotated files that are kept, 0 keeps all of them
+	Keep int `json:"keep" yaml:"keep" toml:"keep"`
+}
+
+// replaces the loggers with ones that use the (validated) configuration, returns an error if the
+// query log file can't be opened
+func configureLogging(loggingConfig LoggingConfig) error {
+	level, _ := logging.ParseLevel(loggingConfig.Level)
+	format, _ := logging.ParseFormat(loggingConfig.Format)
+	logger = logging.New(os.Stdout, level, format)
+	queryLogger = nil
+	if !loggingConfig.Queries {
+		return nil
+	}
+
+	querySample = 1
+	if loggingConfig.QuerySample > 1 {
+		querySample = uint64(loggingConfig.QuerySample)
+	}
+	if loggingConfig.QueryFile.Path == "" {
+		queryLogger = logging.New(os.Stdout, logging.INFO, format)
+		return nil
+	}
+	file, err := logging.OpenFile(loggingConfig.QueryFile.Path, logging.Rotation{
+		MaxSize:  int64(loggingConfig.QueryFile.MaxSize) * 1024 * 1024,
+		MaxAge:   time.Duration(loggingConfig.QueryFile.MaxAge) * time.Hour,
+		Compress: loggingConfig.QueryFile.Compress,
+		Keep:     loggingConfig.QueryFile.Keep,
+	})
+	if err != nil {
+		return fmt.Errorf("the query log file could not be opened: %s", err)
+	}
+	queryFile = file
+	queryLogger = logging.New(file, logging.INFO, format)
+	return nil
+}
+
+// closes the query log file (if there is one) once everything has been written to it
+func closeQueryLog() {
+	if queryFile == nil {
+		return
+	}
+	if err := queryFile.Close(); err != nil {
+		logger.Error("The query log file could not be closed", logging.F("error", err))
+	}
+}
+
+// keeps the response that was written so that it can be logged
+type queryWriter struct {
+	dns.ResponseWriter
+	written *dns.Msg
+}
+
+func (w *queryWriter) WriteMsg(m *dns.Msg) error {
+	w.written = m
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// what was asked and how it was answered, for the query log and metrics
+type queryOutcome struct {
+	name      string
+	qtype     string
+	domain    string
+	command   string
+	answers   []string
+	rcode     string
+	transport string
+	latency   time.Duration
+}
+
+// describes the question and its response (nil when it was not answered)
+func describeQuery(transport string, r *dns.Msg, response *dns.Msg, latency time.Duration) queryOutcome {
+	outcome := queryOutcome{transport: transport, latency: latency, answers: []string{}, rcode: "DROPPED"}
+	if len(r.Question) > 0 {
+		q := r.Question[0]
+		outcome.name = q.Name
+		outcome.qtype = dns.TypeToString[q.Qtype]
+		if outcome.qtype == "" {
+			outcome.qtype = dns.Type(q.Qtype).String()
+		}
+		outcome.domain = domainOf(q.Name)
+		outcome.command = appliedCommand(q.Name)
+		if target, _ := explainTarget(q.Name); q.Qtype == dns.TypeTXT && target != "" {
+			outcome.command = explainLabel
+		}
+	}
+
+	if response != nil {
+		outcome.rcode = dns.RcodeToString[response.Rcode]
+		for _, rr := range response.Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				outcome.answers = append(outcome.answers, record.A.String())
+			case *dns.AAAA:
+				outcome.answers = append(outcome.answers, record.AAAA.String())
+			default:
+				outcome.answers = append(outcome.answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+			}
+		}
+	}
+	return outcome
+}
+
+// wraps the handler so that every question is counted in the metrics and logged (every nth when sampling)
+// with the response it was given. questions that were not answered (dropped by client filtering or rate
+// limiting) have the response code DROPPED.
+func observe(next dns.Handler, transport string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		// the questions the server asks itself would drown out the real ones
+		if isReadinessQuestion(r) {
+			next.ServeDNS(w, r)
+			return
+		}
+
+		start := time.Now()
+		writer := &queryWriter{ResponseWriter: w}
+		next.ServeDNS(writer, r)
+		outcome := describeQuery(transport, r, writer.written, time.Since(start))
+
+		recordQuery(outcome)
+		if !queryLogger.Enabled(logging.INFO) || (querySample > 1 && atomic.AddUint64(&queryCount, 1)%querySample != 0) {
+			return
+		}
+		queryLogger.Info("query", queryFields(remoteIP(w.RemoteAddr()), outcome)...)
+	})
+}
+
+// the fields logged for a question
+func queryFields(client net.IP, outcome queryOutcome) []logging.Field {
+	return []logging.Field{
+		logging.F("client", client),
+		logging.F("transport", outcome.transport),
+		logging.F("name", outcome.name),
+		logging.F("qtype", outcome.qtype),
+		logging.F("domain", outcome.domain),
+		logging.F("command", outcome.command),
+		logging.F("answers", outcome.answers),
+		logging.F("rcode", outcome.rcode),
+		logging.F("latencyMs", float64(outcome.latency.Nanoseconds())/float64(time.Millisecond)),
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/miekg/dns"
+)
+
+func TestAppliedCommand(t *testing.T) {
+	servingDomains = []*DomainConfig{
+		{Name: "public.io.", Keywords: []string{}, Commands: []string{"rr"}},
+		{Name: "strict.io.", Commands: []string{}, Disallowed: "refuse"},
+		{Name: "internal.io."},
+	}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	data := []struct {
+		question string
+		expected string
+	}{
+		{"10.0.0.1.10.0.0.2.rr.public.io.", "rr"},
+		{"10.0.0.1.10.0.0.2.f99.public.io.", "noop"},
+		{"echo.public.io.", ""},
+		{"10.0.0.1.rr.strict.io.", ""},
+		{"10.0.0.1.f50.internal.io.", "fail"},
+		{"REFLECT.internal.io.", "reflect"},
+		{"10.0.0.1.internal.io.", "noop"},
+		{"internal.io.", ""},
+		{"10.0.0.1.gyip.io.", ""},
+	}
+
+	for _, item := range data {
+		if cmd := appliedCommand(item.question); cmd != item.expected {
+			t.Errorf("The query '%s' did not apply the expected command (applied: '%s', expected: '%s')", item.question, cmd, item.expected)
+		}
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	previousLogger := queryLogger
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		queryLogger = previousLogger
+	}()
+
+	data := []struct {
+		question string
+		handler  dns.HandlerFunc
+		answers  []interface{}
+		rcode    string
+		command  string
+	}{
+		{"10.0.0.1.10.0.0.2.rr.gyip.io.", handleQuestions, []interface{}{"10.0.0.1"}, "NOERROR", "rr"},
+		{"10.0.0.1.10.0.0.2.gyip.io.", handleQuestions, []interface{}{"10.0.0.1", "10.0.0.2"}, "NOERROR", "noop"},
+		{"nothing.gyip.io.", handleQuestions, []interface{}{}, "NXDOMAIN", "noop"},
+		{"10.0.0.1.gyip.io.", func(w dns.ResponseWriter, r *dns.Msg) {}, []interface{}{}, "DROPPED", "noop"},
+	}
+
+	for _, item := range data {
+		out := &bytes.Buffer{}
+		queryLogger = logging.New(out, logging.INFO, logging.JSON)
+
+		question := new(dns.Msg)
+		question.SetQuestion(item.question, dns.TypeA)
+		w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.0.0.100"), Port: 5353}}
+		observe(item.handler, "udp").ServeDNS(w, question)
+
+		logged := map[string]interface{}{}
+		if err := json.Unmarshal(out.Bytes(), &logged); err != nil {
+			t.Errorf("The query '%s' was not logged as json: %s (%s)", item.question, err, out.String())
+			continue
+		}
+		expected := map[string]interface{}{
+			"msg":       "query",
+			"client":    "10.0.0.100",
+			"transport": "udp",
+			"name":      item.question,
+			"qtype":     "A",
+			"domain":    "gyip.io.",
+			"command":   item.command,
+			"rcode":     item.rcode,
+		}
+		for key, value := range expected {
+			if logged[key] != value {
+				t.Errorf("The query '%s' was logged with %s '%v', expected '%v'", item.question, key, logged[key], value)
+			}
+		}
+		if answers, _ := logged["answers"].([]interface{}); len(answers) != len(item.answers) {
+			t.Errorf("The query '%s' was logged with answers %v, expected %v", item.question, logged["answers"], item.answers)
+		}
+		if _, found := logged["latencyMs"].(float64); !found {
+			t.Errorf("The query '%s' was logged without a latency", item.question)
+		}
+	}
+
+	// queries aren't logged when the query log is off
+	queryLogger = nil
+	w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.0.0.100"), Port: 5353}}
+	question := new(dns.Msg)
+	question.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+	observe(dns.HandlerFunc(handleQuestions), "udp").ServeDNS(w, question)
+	if w.written == nil {
+		t.Errorf("The query was not answered with the query log off")
+	}
+}
+
+func TestQuerySample(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	previousLogger := queryLogger
+	out := &bytes.Buffer{}
+	queryLogger = logging.New(out, logging.INFO, logging.TEXT)
+	querySample, queryCount = 3, 0
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		queryLogger = previousLogger
+		querySample, queryCount = 1, 0
+	}()
+
+	handler := observe(dns.HandlerFunc(handleQuestions), "udp")
+	answered := 0
+	for idx := 0; idx < 9; idx++ {
+		w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.0.0.100"), Port: 5353}}
+		question := new(dns.Msg)
+		question.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+		handler.ServeDNS(w, question)
+		if w.written != nil {
+			answered++
+		}
+	}
+
+	if answered != 9 {
+		t.Errorf("Every question should have been answered (answered: %d)", answered)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 3 {
+		t.Errorf("One in three questions should have been logged (logged: %d)", lines)
+	}
+}
+
+func TestQueryLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gyip-log")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	previousLogger, previousQueryLogger, previousFile := logger, queryLogger, queryFile
+	defer func() {
+		logger, queryLogger, queryFile = previousLogger, previousQueryLogger, previousFile
+	}()
+
+	path := filepath.Join(dir, "queries.log")
+	if err := configureLogging(LoggingConfig{Queries: true, Format: "json", QueryFile: QueryFileConfig{Path: path}}); err != nil {
+		t.Fatalf("The query log file could not be opened: %s", err)
+	}
+	queryLogger.Info("query", logging.F("name", "10.0.0.1.gyip.io."))
+	closeQueryLog()
+
+	contents, _ := ioutil.ReadFile(path)
+	if !bytes.Contains(contents, []byte(`"name":"10.0.0.1.gyip.io."`)) {
+		t.Errorf("The query was not written to the query log file (contents: %s)", contents)
+	}
+
+	if err := configureLogging(LoggingConfig{Queries: true, QueryFile: QueryFileConfig{Path: filepath.Join(dir, "missing", "queries.log")}}); err == nil {
+		t.Errorf("A query log file in a directory that doesn't exist should not be opened")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/chrisruffalo/gyip/metrics"
+	"github.com/miekg/dns"
+)
+
+// the metrics served by the admin server
+var (
+	metricsRegistry  = metrics.NewRegistry()
+	queryCounter     = metricsRegistry.NewCounter("gyip_queries_total", "Questions by served domain, type, transport, response code, and command applied.", "domain", "qtype", "transport", "rcode", "command")
+	answerCounter    = metricsRegistry.NewCounter("gyip_answers_total", "Answer records sent by served domain and question type.", "domain", "qtype")
+	queryLatency     = metricsRegistry.NewHistogram("gyip_query_duration_seconds", "Time taken to answer questions by transport.", metrics.DefaultBuckets, "transport")
+	parseFailures    = metricsRegistry.NewCounter("gyip_parse_failures_total", "Messages that could not be parsed by transport.", "transport")
+	leaseExpirations = metricsRegistry.NewCounter("gyip_lease_expirations_total", "Registered records that were removed because their lease lapsed.")
+	listenerErrors   = metricsRegistry.NewCounter("gyip_listener_errors_total", "Listeners that could not be started or that failed while serving by transport.", "transport")
+)
+
+// the label for the type of a question, types without a name are counted together as "other" so that
+// clients can't add a label value for each of the 65536 types
+func typeLabel(qtype string) string {
+	if _, known := dns.StringToType[qtype]; !known {
+		return "other"
+	}
+	return qtype
+}
+
+// counts the question and its answers
+func recordQuery(outcome queryOutcome) {
+	qtype := typeLabel(outcome.qtype)
+	queryCounter.Inc(outcome.domain, qtype, outcome.transport, outcome.rcode, outcome.command)
+	if len(outcome.answers) > 0 {
+		answerCounter.Add(float64(len(outcome.answers)), outcome.domain, qtype)
+	}
+	queryLatency.Observe(outcome.latency.Seconds(), outcome.transport)
+}
+
+// counts the messages that can't be parsed. the dns server answers them with FORMERR before they get to
+// a handler, none of the handlers answer with FORMERR, so every FORMERR that is written is a parse failure.
+type parseFailureWriter struct {
+	dns.Writer
+	transport string
+}
+
+func (w *parseFailureWriter) Write(b []byte) (int, error) {
+	// the response code is the low four bits of the fourth byte of the header
+	if len(b) > 3 && int(b[3]&0x0f) == dns.RcodeFormatError {
+		parseFailures.Inc(w.transport)
+	}
+	return w.Writer.Write(b)
+}
+
+// a dns.DecorateWriter that counts the parse failures of the transport
+func countParseFailures(transport string) dns.DecorateWriter {
+	return func(w dns.Writer) dns.Writer {
+		return &parseFailureWriter{Writer: w, transport: transport}
+	}
+}
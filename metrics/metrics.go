@@ -0,0 +1,275 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the media type of the prometheus text format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// separates the label values in the key of each series
+const labelSeparator = "\xff"
+
+// DefaultBuckets - histogram buckets (in seconds) for the time taken to answer a question
+var DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// a metric that can be written in the prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry - a set of metrics that are written together
+type Registry struct {
+	lock    sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry - a registry without any metrics
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// adds the metric to those that are written
+func (registry *Registry) register(m metric) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Write - writes every metric in the prometheus text format, in the order they were created
+func (registry *Registry) Write(w io.Writer) error {
+	registry.lock.Lock()
+	metrics := append([]metric{}, registry.metrics...)
+	registry.lock.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// ServeHTTP - serves the metrics to a prometheus scrape
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	registry.Write(w)
+}
+
+// the name, help, and label names shared by every kind of metric
+type family struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// writes the help and type lines of the metric
+func (f *family) writeHeader(w io.Writer, kind string) {
+	help := strings.Replace(strings.Replace(f.help, `\`, `\\`, -1), "\n", `\n`, -1)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, help, f.name, kind)
+}
+
+// the key of the series with the label values, the values must match the label names
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels but was given %d values", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, labelSeparator)
+}
+
+// the labels of a series in the text format, with any extra label (like le) at the end
+func (f *family) labelText(key string, extra ...string) string {
+	pairs := []string{}
+	if len(f.labels) > 0 {
+		for idx, value := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, f.labels[idx]+"="+quote(value))
+		}
+	}
+	for idx := 0; idx+1 < len(extra); idx += 2 {
+		pairs = append(pairs, extra[idx]+"="+quote(extra[idx+1]))
+	}
+	if len(pairs) < 1 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// a label value in quotes with backslashes, quotes, and newlines escaped
+func quote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return `"` + value + `"`
+}
+
+// a number in the text format
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// the keys of the series in a stable order
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter - a value for each set of label values that only goes up
+type Counter struct {
+	family
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter - a counter with the given label names that is written with the registry
+func (registry *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	counter := &Counter{family: family{name: name, help: help, labels: labels}, values: map[string]float64{}}
+	registry.register(counter)
+	return counter
+}
+
+// Add - adds the amount (which can't be negative) to the series with the label values
+func (counter *Counter) Add(amount float64, values ...string) {
+	if amount < 0 {
+		panic(fmt.Sprintf("metrics: %s can't go down", counter.name))
+	}
+	key := counter.key(values)
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.values[key] += amount
+}
+
+// Inc - adds one to the series with the label values
+func (counter *Counter) Inc(values ...string) {
+	counter.Add(1, values...)
+}
+
+// Value - the current value of the series with the label values
+func (counter *Counter) Value(values ...string) float64 {
+	key := counter.key(values)
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	return counter.values[key]
+}
+
+// Totals - the sum of every series for each value of the label, or the sum of every series under the
+// empty value when the counter has no label with the name
+func (counter *Counter) Totals(label string) map[string]float64 {
+	index := -1
+	for idx, name := range counter.labels {
+		if name == label {
+			index = idx
+		}
+	}
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	totals := map[string]float64{}
+	for key, value := range counter.values {
+		if index < 0 {
+			totals[""] += value
+			continue
+		}
+		totals[strings.Split(key, labelSeparator)[index]] += value
+	}
+	return totals
+}
+
+func (counter *Counter) write(w io.Writer) {
+	counter.writeHeader(w, "counter")
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	keys := []string{}
+	for key := range counter.values {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		fmt.Fprintf(w, "%s%s %s\n", counter.name, counter.labelText(key), formatValue(counter.values[key]))
+	}
+}
+
+// the observations of a single histogram series
+type histogramValue struct {
+	// the number of observations in each bucket (not cumulative)
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Histogram - counts observations in buckets for each set of label values
+type Histogram struct {
+	family
+	// the upper bound of each bucket, in increasing order
+	bounds []float64
+	lock   sync.Mutex
+	values map[string]*histogramValue
+}
+
+// NewHistogram - a histogram with the given bucket upper bounds and label names that is written with the registry
+func (registry *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	bounds := append([]float64{}, buckets...)
+	sort.Float64s(bounds)
+	histogram := &Histogram{family: family{name: name, help: help, labels: labels}, bounds: bounds, values: map[string]*histogramValue{}}
+	registry.register(histogram)
+	return histogram
+}
+
+// Observe - counts the value in the series with the label values
+func (histogram *Histogram) Observe(value float64, values ...string) {
+	key := histogram.key(values)
+	bucket := sort.SearchFloat64s(histogram.bounds, value)
+
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	series, found := histogram.values[key]
+	if !found {
+		// the last bucket is +Inf
+		series = &histogramValue{buckets: make([]uint64, len(histogram.bounds)+1)}
+		histogram.values[key] = series
+	}
+	series.buckets[bucket]++
+	series.count++
+	series.sum += value
+}
+
+// Count - the number of values observed in the series with the label values
+func (histogram *Histogram) Count(values ...string) uint64 {
+	key := histogram.key(values)
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	if series, found := histogram.values[key]; found {
+		return series.count
+	}
+	return 0
+}
+
+func (histogram *Histogram) write(w io.Writer) {
+	histogram.writeHeader(w, "histogram")
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	keys := []string{}
+	for key := range histogram.values {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		series := histogram.values[key]
+		cumulative := uint64(0)
+		for idx, bound := range append(histogram.bounds, math.Inf(1)) {
+			cumulative += series.buckets[idx]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, histogram.labelText(key, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", histogram.name, histogram.labelText(key), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", histogram.name, histogram.labelText(key), series.count)
+	}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	registry := NewRegistry()
+	queries := registry.NewCounter("test_queries_total", "Questions answered", "domain", "rcode")
+	plain := registry.NewCounter("test_errors_total", "Errors\nwith a newline")
+
+	queries.Inc("gyip.io.", "NOERROR")
+	queries.Inc("gyip.io.", "NOERROR")
+	queries.Add(3, "gyip.io.", "NXDOMAIN")
+	queries.Inc("quote\".io.", "NOERROR")
+	plain.Inc()
+
+	if queries.Value("gyip.io.", "NOERROR") != 2 || queries.Value("other.io.", "NOERROR") != 0 {
+		t.Errorf("The counter does not have the expected values")
+	}
+	if totals := queries.Totals("rcode"); !reflect.DeepEqual(totals, map[string]float64{"NOERROR": 3, "NXDOMAIN": 3}) {
+		t.Errorf("The counter totals by response code were %v", totals)
+	}
+	if totals := queries.Totals("missing"); !reflect.DeepEqual(totals, map[string]float64{"": 6}) {
+		t.Errorf("The counter total was %v", totals)
+	}
+
+	out := &bytes.Buffer{}
+	registry.Write(out)
+	expected := `# HELP test_queries_total Questions answered
+# TYPE test_queries_total counter
+test_queries_total{domain="gyip.io.",rcode="NOERROR"} 2
+test_queries_total{domain="gyip.io.",rcode="NXDOMAIN"} 3
+test_queries_total{domain="quote\".io.",rcode="NOERROR"} 1
+# HELP test_errors_total Errors\nwith a newline
+# TYPE test_errors_total counter
+test_errors_total 1
+`
+	if out.String() != expected {
+		t.Errorf("The counters were written as:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	latency := registry.NewHistogram("test_duration_seconds", "Time taken", []float64{0.5, 0.1}, "transport")
+
+	for _, value := range []float64{0.05, 0.1, 0.3, 2} {
+		latency.Observe(value, "udp")
+	}
+	if latency.Count("udp") != 4 || latency.Count("tcp") != 0 {
+		t.Errorf("The histogram does not have the expected counts")
+	}
+
+	out := &bytes.Buffer{}
+	registry.Write(out)
+	expected := `# HELP test_duration_seconds Time taken
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{transport="udp",le="0.1"} 2
+test_duration_seconds_bucket{transport="udp",le="0.5"} 3
+test_duration_seconds_bucket{transport="udp",le="+Inf"} 4
+test_duration_seconds_sum{transport="udp"} 2.45
+test_duration_seconds_count{transport="udp"} 4
+`
+	if out.String() != expected {
+		t.Errorf("The histogram was written as:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("test_total", "Things").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Header().Get("Content-Type") != contentType {
+		t.Errorf("The metrics were served as '%s'", recorder.Header().Get("Content-Type"))
+	}
+	if !bytes.Contains(recorder.Body.Bytes(), []byte("test_total 1\n")) {
+		t.Errorf("The metrics were not served (body: %s)", recorder.Body.String())
+	}
+}
+
+func TestLabelMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Giving the wrong number of label values should panic")
+		}
+	}()
+	NewRegistry().NewCounter("test_total", "Things", "domain").Inc("gyip.io.", "extra")
+}
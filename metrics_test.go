@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordQuery(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	previousLogger := queryLogger
+	queryLogger = nil
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		queryLogger = previousLogger
+	}()
+
+	data := []struct {
+		question string
+		qtype    uint16
+		rcode    string
+		command  string
+		answers  float64
+		label    string
+	}{
+		{"10.0.0.1.10.0.0.2.rr.gyip.io.", dns.TypeA, "NOERROR", "rr", 1, "A"},
+		{"10.0.0.1.10.0.0.2.gyip.io.", dns.TypeA, "NOERROR", "noop", 2, "A"},
+		{"nothing.gyip.io.", dns.TypeAAAA, "NXDOMAIN", "noop", 0, "AAAA"},
+		// types without a name share a label
+		{"10.0.0.1.gyip.io.", 1234, "NXDOMAIN", "noop", 0, "other"},
+		{"10.0.0.1.gyip.io.", 4321, "NXDOMAIN", "noop", 0, "other"},
+	}
+
+	handler := observe(dns.HandlerFunc(handleQuestions), "tcp")
+	for _, item := range data {
+		qtype := item.label
+		queries := queryCounter.Value("gyip.io.", qtype, "tcp", item.rcode, item.command)
+		answers := answerCounter.Value("gyip.io.", qtype)
+		latencies := queryLatency.Count("tcp")
+
+		question := new(dns.Msg)
+		question.SetQuestion(item.question, item.qtype)
+		handler.ServeDNS(&testWriter{remote: &net.TCPAddr{IP: net.ParseIP("10.0.0.100"), Port: 5353}}, question)
+
+		if queryCounter.Value("gyip.io.", qtype, "tcp", item.rcode, item.command)-queries != 1 {
+			t.Errorf("The query '%s' was not counted with response code %s and command %s", item.question, item.rcode, item.command)
+		}
+		if answerCounter.Value("gyip.io.", qtype)-answers != item.answers {
+			t.Errorf("The query '%s' did not count %v answers", item.question, item.answers)
+		}
+		if queryLatency.Count("tcp")-latencies != 1 {
+			t.Errorf("The time taken to answer query '%s' was not observed", item.question)
+		}
+	}
+}
+
+func TestParseFailures(t *testing.T) {
+	defer resetRunning()
+	s, err := listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not bind a udp socket: %s", err)
+	}
+	started := make(chan error)
+	go serveSocket(s, dns.HandlerFunc(handleQuestions), started)
+	if err := <-started; err != nil {
+		t.Fatalf("Could not serve the udp socket: %s", err)
+	}
+	defer s.stop()
+
+	before := parseFailures.Value("udp")
+	conn, err := net.Dial("udp", s.addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to the udp socket: %s", err)
+	}
+	defer conn.Close()
+	// a header that says there is a question without the question
+	conn.Write([]byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response := make([]byte, 512)
+	if _, err := conn.Read(response); err != nil {
+		t.Fatalf("The message that could not be parsed was not answered: %s", err)
+	}
+
+	if parseFailures.Value("udp")-before != 1 {
+		t.Errorf("The message that could not be parsed was not counted")
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+	listenerErrors.Inc("dot")
+
+	recorder := httptest.NewRecorder()
+	adminHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	for _, expected := range []string{"# TYPE gyip_queries_total counter", "# TYPE gyip_query_duration_seconds histogram", "gyip_listener_errors_total{transport=\"dot\"}"} {
+		if !bytes.Contains(recorder.Body.Bytes(), []byte(expected)) {
+			t.Errorf("The metrics did not contain '%s'", expected)
+		}
+	}
+}
+
+func TestListenAdmin(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not bind an address: %s", err)
+	}
+	address := taken.Addr().String()
+
+	if _, err := listenAdmin(address, 0); err == nil {
+		t.Errorf("An address that is in use should not be bound")
+	}
+
+	// the address is bound once the listener that has it goes away
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		taken.Close()
+	}()
+	listener, err := listenAdmin(address, 2*time.Second)
+	if err != nil {
+		t.Fatalf("The address should have been bound once it was free: %s", err)
+	}
+	listener.Close()
+}
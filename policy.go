@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/chrisruffalo/gyip/acl"
+	"github.com/chrisruffalo/gyip/command"
+)
+
+// the keywords that can be honored by a domain
+var policyKeywords = []string{"echo", "reflect", explainLabel}
+
+// the address encodings that can be honored by a domain
+var policyEncodings = []string{"ipv4", "ipv6"}
+
+// what can be done with a question that uses something the domain does not honor
+const (
+	policyIgnore = "ignore"
+	policyRefuse = "refuse"
+)
+
+var policyActions = []string{policyIgnore, policyRefuse}
+
+// the networks that a preset starts with
+type answerPreset struct {
+	allow []string
+	deny  []string
+}
+
+// addresses that point back at the host or at cloud metadata services, these are the targets of dns-rebinding
+// and are denied by every preset except "any"
+var metadataNetworks = []string{
+	"169.254.0.0/16",     // ipv4 link-local, includes 169.254.169.254 (aws, gcp, azure metadata)
+	"fe80::/10",          // ipv6 link-local
+	"fd00:ec2::254/128",  // aws ipv6 metadata
+	"100.100.100.200/32", // alibaba cloud metadata
+}
+
+// presets for the addresses that can be given in answers
+var answerPresets = map[string]answerPreset{
+	// every address, the behavior before presets were added
+	"any": {},
+	// every unicast address that doesn't point at a metadata service
+	"safe": {
+		deny: append([]string{"0.0.0.0/8", "224.0.0.0/4", "240.0.0.0/4", "::/128", "ff00::/8"}, metadataNetworks...),
+	},
+	// only private (rfc1918, unique local) and loopback addresses
+	"private": {
+		allow: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "fc00::/7", "::1/128"},
+		deny:  metadataNetworks,
+	},
+	// only loopback addresses
+	"loopback": {
+		allow: []string{"127.0.0.0/8", "::1/128"},
+	},
+}
+
+// the preset used when none is given
+const defaultAnswerPreset = "safe"
+
+// the answer addresses of names that are not in a served domain, these are the top level answers once the
+// configuration is finished so that nothing falls back to allowing every address
+var defaultAnswers, _ = buildAnswerList(AddressConfig{}, AddressConfig{})
+
+// sorted preset names for messages
+func answerPresetNames() []string {
+	names := []string{}
+	for name := range answerPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// builds the list of answer addresses for a domain. the domain preset and allowed networks replace
+// the defaults when given while denied networks are added to the defaults.
+func buildAnswerList(defaults AddressConfig, domainAddresses AddressConfig) (*acl.List, error) {
+	presetName := defaults.Preset
+	if domainAddresses.Preset != "" {
+		presetName = domainAddresses.Preset
+	}
+	if presetName == "" {
+		presetName = defaultAnswerPreset
+	}
+	preset, found := answerPresets[strings.ToLower(presetName)]
+	if !found {
+		return nil, fmt.Errorf("\"%s\" is not one of %v", presetName, answerPresetNames())
+	}
+
+	allow := preset.allow
+	if defaults.Allow != nil {
+		allow = defaults.Allow
+	}
+	if domainAddresses.Allow != nil {
+		allow = domainAddresses.Allow
+	}
+
+	deny := append(append(append([]string{}, preset.deny...), defaults.Deny...), domainAddresses.Deny...)
+
+	return acl.New(allow, deny)
+}
+
+// returned when the domain refuses to answer a question
+var errRefused = errors.New("refused by domain policy")
+
+// returns true if the domain honors the given list entry, a missing (nil) list honors everything
+func honors(allowed []string, value string) bool {
+	if allowed == nil {
+		return true
+	}
+	for _, check := range allowed {
+		if strings.ToLower(check) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the domain honors the keyword (echo, reflect, explain)
+func (domainConfig *DomainConfig) allowsKeyword(keyword string) bool {
+	return honors(domainConfig.Keywords, strings.ToLower(keyword))
+}
+
+// returns true if the domain honors the command, the noop command is always honored
+func (domainConfig *DomainConfig) allowsCommand(cmdType command.Type) bool {
+	return cmdType == command.NOOP || honors(domainConfig.Commands, cmdType.String())
+}
+
+// returns true if the domain honors the encoding that the address was given in
+func (domainConfig *DomainConfig) allowsEncoding(ip net.IP) bool {
+	encoding := "ipv6"
+	if ip.To4() != nil {
+		encoding = "ipv4"
+	}
+	return honors(domainConfig.Encodings, encoding)
+}
+
+// returns true if the domain can give the address in an answer, domains that were not built from
+// a configuration can give any address
+func (domainConfig *DomainConfig) allowsAnswer(ip net.IP) bool {
+	return domainConfig.answers.Permits(ip)
+}
+
+// returns true if questions that use something that is not honored should be refused instead of ignored
+func (domainConfig *DomainConfig) refuses() bool {
+	return strings.ToLower(domainConfig.Disallowed) == policyRefuse
+}
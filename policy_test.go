@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/chrisruffalo/gyip/command"
+	"github.com/miekg/dns"
+)
+
+func TestDomainPolicy(t *testing.T) {
+	servingDomains = []*DomainConfig{
+		{Name: "public.io.", Keywords: []string{}, Commands: []string{"rr"}, Encodings: []string{"ipv4"}},
+		{Name: "strict.io.", Keywords: []string{"reflect"}, Commands: []string{}, Encodings: []string{"ipv6"}, Disallowed: "refuse"},
+		{Name: "internal.io."},
+	}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	source := net.ParseIP("10.0.0.100")
+
+	data := []struct {
+		dnsType        uint16
+		questionDomain string
+		inputQuestion  string
+		expectedCount  int
+		refused        bool
+	}{
+		// ignored
+		{dns.TypeA, "public.io", "echo.public.io", 0, false},
+		{dns.TypeA, "public.io", "10.0.0.1.10.0.0.2.rr.public.io", 1, false},
+		{dns.TypeA, "public.io", "10.0.0.1.10.0.0.2.f99.public.io", 2, false},
+		{dns.TypeAAAA, "public.io", "10.0.0.1.::1.public.io", 1, false},
+		{dns.TypeAAAA, "public.io", "::1.public.io", 0, false},
+		// refused
+		{dns.TypeA, "strict.io", "echo.strict.io", 0, true},
+		{dns.TypeA, "strict.io", "reflect.strict.io", 1, false},
+		{dns.TypeAAAA, "strict.io", "::1.::2.rr.strict.io", 0, true},
+		{dns.TypeAAAA, "strict.io", "::1.::2.strict.io", 2, false},
+		{dns.TypeAAAA, "strict.io", "10.0.0.1.::1.strict.io", 0, true},
+		// everything allowed
+		{dns.TypeA, "internal.io", "echo.internal.io", 1, false},
+		{dns.TypeAAAA, "internal.io", "10.0.0.1.::1.rr.internal.io", 1, false},
+	}
+
+	for _, item := range data {
+		records, err := frameResponse(source, item.dnsType, item.inputQuestion, item.questionDomain)
+		if item.refused != (err == errRefused) {
+			t.Errorf("The query '%s' was not refused as expected (was: %v, expected refused: %t)", item.inputQuestion, err, item.refused)
+		}
+		if len(records) != item.expectedCount {
+			t.Errorf("The query '%s' did not return the expected number of records (returned %d, expected %d)", item.inputQuestion, len(records), item.expectedCount)
+		}
+	}
+}
+
+func TestPolicyChecks(t *testing.T) {
+	domainConfig := &DomainConfig{Keywords: []string{"ECHO"}, Commands: []string{"fail"}, Encodings: []string{"ipv6"}}
+	if !domainConfig.allowsKeyword("echo") || domainConfig.allowsKeyword("reflect") {
+		t.Errorf("The domain did not allow the expected keywords")
+	}
+	if !domainConfig.allowsCommand(command.NOOP) || !domainConfig.allowsCommand(command.FAIL) || domainConfig.allowsCommand(command.RR) {
+		t.Errorf("The domain did not allow the expected commands")
+	}
+	if !domainConfig.allowsEncoding(net.ParseIP("::1")) || domainConfig.allowsEncoding(net.ParseIP("127.0.0.1")) {
+		t.Errorf("The domain did not allow the expected encodings")
+	}
+	if domainConfig.refuses() {
+		t.Errorf("The domain should ignore disallowed questions by default")
+	}
+}
+
+func TestAnswerRestrictions(t *testing.T) {
+	safe, _ := buildAnswerList(AddressConfig{Preset: "safe"}, AddressConfig{})
+	private, _ := buildAnswerList(AddressConfig{Preset: "safe"}, AddressConfig{Preset: "private", Deny: []string{"10.0.0.13"}})
+	labs, _ := buildAnswerList(AddressConfig{Deny: []string{"10.0.0.13"}}, AddressConfig{Allow: []string{"10.0.0.0/24"}})
+	servingDomains = []*DomainConfig{
+		{Name: "safe.io.", answers: safe},
+		{Name: "private.io.", answers: private},
+		{Name: "strict.io.", answers: private, Disallowed: "refuse"},
+		{Name: "labs.io.", answers: labs},
+	}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	data := []struct {
+		dnsType        uint16
+		questionDomain string
+		inputQuestion  string
+		expectedCount  int
+		refused        bool
+	}{
+		{dns.TypeA, "safe.io", "169.254.169.254.safe.io", 0, false},
+		{dns.TypeA, "safe.io", "45.13.12.90.169.254.169.254.safe.io", 1, false},
+		{dns.TypeAAAA, "safe.io", "fd00:ec2::254.safe.io", 0, false},
+		{dns.TypeAAAA, "safe.io", "2001:db8::1.safe.io", 1, false},
+		{dns.TypeA, "private.io", "45.13.12.90.private.io", 0, false},
+		{dns.TypeA, "private.io", "10.0.0.1.192.168.1.1.127.0.0.1.private.io", 3, false},
+		{dns.TypeA, "private.io", "10.0.0.13.private.io", 0, false},
+		{dns.TypeA, "strict.io", "10.0.0.1.45.13.12.90.strict.io", 0, true},
+		{dns.TypeA, "strict.io", "10.0.0.1.strict.io", 1, false},
+		{dns.TypeA, "labs.io", "10.0.0.1.10.0.1.1.labs.io", 1, false},
+		{dns.TypeA, "labs.io", "10.0.0.13.labs.io", 0, false},
+		// echo returns the client address and is not limited
+		{dns.TypeA, "private.io", "echo.private.io", 1, false},
+		// names outside of the served domains only get the default answers
+		{dns.TypeA, "unserved.io", "169.254.169.254.unserved.io", 0, false},
+		{dns.TypeA, "unserved.io", "10.0.0.1.unserved.io", 1, false},
+	}
+
+	for _, item := range data {
+		records, err := frameResponse(net.ParseIP("45.13.12.90"), item.dnsType, item.inputQuestion, item.questionDomain)
+		if item.refused != (err == errRefused) {
+			t.Errorf("The query '%s' was not refused as expected (was: %v, expected refused: %t)", item.inputQuestion, err, item.refused)
+		}
+		if len(records) != item.expectedCount {
+			t.Errorf("The query '%s' did not return the expected number of records (returned %d, expected %d)", item.inputQuestion, len(records), item.expectedCount)
+		}
+	}
+
+	if _, err := buildAnswerList(AddressConfig{Preset: "everything"}, AddressConfig{}); err == nil {
+		t.Errorf("An unknown preset should not build a list")
+	}
+}
+
+func TestMixedCaseDomains(t *testing.T) {
+	safe, _ := buildAnswerList(AddressConfig{Preset: "safe"}, AddressConfig{})
+	servingDomains = []*DomainConfig{
+		{Name: "gyip.io.", answers: safe, Commands: []string{"rr"}, Disallowed: "refuse"},
+	}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	// the dns mux routes names without regard to case so the policy of the domain has to apply to them too
+	data := []struct {
+		question string
+		rcode    int
+		answers  int
+	}{
+		{"169.254.169.254.gyip.io.", dns.RcodeRefused, 0},
+		{"169.254.169.254.GYIP.io.", dns.RcodeRefused, 0},
+		{"10.0.0.1.f50.Gyip.IO.", dns.RcodeRefused, 0},
+		{"10.0.0.1.10.0.0.2.RR.Gyip.IO.", dns.RcodeSuccess, 1},
+		{"10.0.0.1.GYIP.IO.", dns.RcodeSuccess, 1},
+	}
+
+	for _, item := range data {
+		w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("10.0.0.100")}}
+		r := new(dns.Msg)
+		r.SetQuestion(item.question, dns.TypeA)
+		handleQuestions(w, r)
+		if w.written == nil || w.written.Rcode != item.rcode || len(w.written.Answer) != item.answers {
+			t.Errorf("The question '%s' was not answered with %s and %d answers (was: %v)", item.question, dns.RcodeToString[item.rcode], item.answers, w.written)
+		}
+	}
+}
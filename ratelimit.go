@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/chrisruffalo/gyip/rrl"
+	"github.com/miekg/dns"
+)
+
+// the limiter shared by every udp listener, nil when rate limiting is off
+var responseLimiter *rrl.Limiter
+
+// RateLimitConfig - options for limiting the rate of udp responses to each client prefix
+type RateLimitConfig struct {
+	// the number of responses of each kind (answer, nxdomain, error) a client prefix can get each second, 0 turns limiting off
+	ResponsesPerSecond int `json:"responsesPerSecond" yaml:"responsesPerSecond" toml:"responsesPerSecond"`
+	// the number of seconds over which responses are counted
+	Window int `json:"window" yaml:"window" toml:"window"`
+	// every nth limited response is sent back truncated instead of being dropped, 0 never slips
+	Slip int `json:"slip" yaml:"slip" toml:"slip"`
+	// the prefix lengths that group clients together
+	IPv4Prefix int `json:"ipv4Prefix" yaml:"ipv4Prefix" toml:"ipv4Prefix"`
+	IPv6Prefix int `json:"ipv6Prefix" yaml:"ipv6Prefix" toml:"ipv6Prefix"`
+}
+
+// writes responses only when the limiter allows them
+type limitedWriter struct {
+	dns.ResponseWriter
+	limiter *rrl.Limiter
+}
+
+func (w *limitedWriter) WriteMsg(m *dns.Msg) error {
+	switch w.limiter.Check(remoteIP(w.RemoteAddr()), responseCategory(m), time.Now()) {
+	case rrl.DROP:
+		return nil
+	case rrl.SLIP:
+		// an empty truncated response tells a real client to ask again over tcp
+		slip := new(dns.Msg)
+		slip.MsgHdr = m.MsgHdr
+		slip.Question = m.Question
+		slip.Truncated = true
+		return w.ResponseWriter.WriteMsg(slip)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// the kind of response for rate limiting
+func responseCategory(m *dns.Msg) rrl.Category {
+	switch extendedRcode(m) {
+	case dns.RcodeSuccess:
+		return rrl.ANSWER
+	case dns.RcodeNameError:
+		return rrl.NXDOMAIN
+	}
+	return rrl.ERROR
+}
+
+// wraps the handler so that udp responses are rate limited, tcp responses can't be sent to a forged address
+// and are never limited
+func rateLimit(next dns.Handler, limiter *rrl.Limiter) dns.Handler {
+	if !limiter.Enabled() {
+		return next
+	}
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, isUDP := w.RemoteAddr().(*net.UDPAddr); isUDP {
+			w = &limitedWriter{ResponseWriter: w, limiter: limiter}
+		}
+		next.ServeDNS(w, r)
+	})
+}
+
+// logs the number of limited responses every interval that had any
+func reportRateLimits(limiter *rrl.Limiter, interval time.Duration) {
+	last := limiter.Stats()
+	for range time.Tick(interval) {
+		current := limiter.Stats()
+		dropped := current.Dropped - last.Dropped
+		slipped := current.Slipped - last.Slipped
+		if dropped > 0 || slipped > 0 {
+			logger.Info("Rate limited responses", logging.F("limited", dropped+slipped), logging.F("responses", current.Responses-last.Responses), logging.F("dropped", dropped), logging.F("slipped", slipped), logging.F("interval", interval))
+		}
+		last = current
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/chrisruffalo/gyip/rrl"
+	"github.com/miekg/dns"
+)
+
+func TestRateLimit(t *testing.T) {
+	limiter := rrl.New(rrl.Config{ResponsesPerSecond: 2, Slip: 2})
+	handler := rateLimit(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("10.0.0.1")})
+		w.WriteMsg(m)
+	}), limiter)
+
+	data := []struct {
+		remote    net.Addr
+		written   bool
+		truncated bool
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, true, false},
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, true, false},
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, false, false},
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, true, true},
+		// tcp is never limited
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}, true, false},
+		// another client network is not limited
+		{&net.UDPAddr{IP: net.ParseIP("10.0.1.1")}, true, false},
+	}
+
+	for idx, item := range data {
+		w := &testWriter{remote: item.remote}
+		r := new(dns.Msg)
+		r.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+		handler.ServeDNS(w, r)
+
+		if written := w.written != nil; written != item.written {
+			t.Errorf("Response %d to %s was not written as expected (was: %t, expected %t)", idx, item.remote, written, item.written)
+			continue
+		}
+		if w.written != nil && w.written.Truncated != item.truncated {
+			t.Errorf("Response %d to %s was not truncated as expected (was: %t, expected %t)", idx, item.remote, w.written.Truncated, item.truncated)
+		}
+		if w.written != nil && w.written.Truncated && len(w.written.Answer) > 0 {
+			t.Errorf("Response %d to %s was truncated but still has answers", idx, item.remote)
+		}
+	}
+
+	if stats := limiter.Stats(); stats.Dropped != 1 || stats.Slipped != 1 {
+		t.Errorf("The limiter did not count the limited responses (was: %+v)", stats)
+	}
+
+	// without a limit the handler is not wrapped
+	next := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {})
+	if _, wrapped := rateLimit(next, rrl.New(rrl.Config{})).(dns.HandlerFunc); !wrapped {
+		t.Errorf("The handler should not be wrapped when rate limiting is off")
+	}
+}
+
+func TestResponseCategory(t *testing.T) {
+	data := []struct {
+		rcode    int
+		expected rrl.Category
+	}{
+		{dns.RcodeSuccess, rrl.ANSWER},
+		{dns.RcodeNameError, rrl.NXDOMAIN},
+		{dns.RcodeRefused, rrl.ERROR},
+		{dns.RcodeNotZone, rrl.ERROR},
+		// the lower bits of BADVERS are the same as NOERROR
+		{dns.RcodeBadVers, rrl.ERROR},
+	}
+
+	request := new(dns.Msg)
+	request.SetEdns0(dns.MinMsgSize, false)
+	for _, item := range data {
+		response := new(dns.Msg)
+		setResponseEdns(request, response, item.rcode)
+		if category := responseCategory(response); category != item.expected {
+			t.Errorf("The response code %d was not given the expected category (was: %v, expected %v)", item.rcode, category, item.expected)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/chrisruffalo/gyip/records"
+)
+
+// the ttl of registered records that don't give one
+const defaultRecordTTL = 60
+
+// the largest record that will be read from a request
+const maxRecordBody = 64 * 1024
+
+// the largest snapshot that will be read from a request
+const maxSnapshotBody = 16 * 1024 * 1024
+
+// the path of the records api, a record is at the path followed by its name
+const recordsPath = "/v1/records"
+
+// the path that every record is exported from and imported to as a json snapshot
+const snapshotPath = "/v1/snapshot"
+
+// the end of the path that renews the lease of a record
+const renewSuffix = "/renew"
+
+// how often records whose lease has lapsed are removed
+const leaseSweepInterval = 5 * time.Second
+
+// the records registered through the admin api, these are answered before the addresses in a name are parsed
+var recordStore = records.NewStore()
+
+// the bearer token that the records api requires (from the combined configuration file and command line),
+// the api is off without one
+var recordsToken string
+
+// RecordsConfig - where registered records are kept
+type RecordsConfig struct {
+	// the file that records and their leases are kept in so that they outlast a restart, records are only
+	// kept in memory without one
+	File string `json:"file" yaml:"file" toml:"file"`
+	// a json snapshot of records that is imported when the server starts
+	Import string `json:"import" yaml:"import" toml:"import"`
+}
+
+// loads the records from the file (if one is given) and imports the snapshot (if one is given)
+func configureRecords(recordsConfig RecordsConfig) error {
+	if recordsConfig.File != "" {
+		storage, err := records.OpenFile(recordsConfig.File)
+		if err != nil {
+			return fmt.Errorf("the records file could not be opened: %s", err)
+		}
+		store, err := records.Open(storage)
+		if err != nil {
+			storage.Close()
+			return fmt.Errorf("the records file could not be loaded: %s", err)
+		}
+		recordStore = store
+		// the served domains and the addresses they answer with may have changed since the records were kept
+		for _, record := range store.List() {
+			if _, err := checkRecord(record); err != nil {
+				if _, err := store.Delete(record.Name); err != nil {
+					return fmt.Errorf("the record %s could not be removed from the records file: %s", record.Name, err)
+				}
+				logger.Warn("Record dropped", logging.F("name", record.Name), logging.F("error", err))
+			}
+		}
+		logger.Info("Records loaded", logging.F("file", recordsConfig.File), logging.F("records", store.Len()))
+	}
+
+	if recordsConfig.Import != "" {
+		file, err := os.Open(recordsConfig.Import)
+		if err != nil {
+			return fmt.Errorf("the records snapshot could not be opened: %s", err)
+		}
+		defer file.Close()
+		list, err := records.ReadSnapshot(file)
+		if err != nil {
+			return fmt.Errorf("the records snapshot could not be read: %s", err)
+		}
+		imported, err := importRecords(list)
+		if err != nil {
+			return fmt.Errorf("the records snapshot could not be imported: %s", err)
+		}
+		logger.Info("Records imported", logging.F("file", recordsConfig.Import), logging.F("records", imported))
+	}
+	return nil
+}
+
+// stops writing to the records file
+func closeRecords() {
+	if err := recordStore.Close(); err != nil {
+		logger.Error("The records file could not be closed", logging.F("error", err))
+	}
+}
+
+// puts every record from a snapshot, each one is checked as if it was put through the api and none of them
+// are put unless all of them can be. records whose lease has lapsed are left out. the number put is returned.
+func importRecords(list []records.Record) (int, error) {
+	now := time.Now()
+	checked := []records.Record{}
+	for _, record := range list {
+		if !record.Expires.IsZero() && !now.Before(record.Expires) {
+			continue
+		}
+		imported, err := checkRecord(record)
+		if err != nil {
+			return 0, err
+		}
+		checked = append(checked, imported)
+	}
+	if err := recordStore.PutAll(checked); err != nil {
+		return 0, err
+	}
+	return len(checked), nil
+}
+
+// checks a record that was not put through the api (one that was kept or exported) as if it had been. the
+// lease of the checked record ends when it did for the record, as it would have on the server it came from.
+func checkRecord(record records.Record) (records.Record, error) {
+	response := newRecordResponse(record)
+	request := recordRequest{A: response.A, AAAA: response.AAAA, TTL: record.TTL, Lease: response.Lease}
+	checked, err := newRecord(records.Name(record.Name), request)
+	checked.Expires = record.Expires
+	return checked, err
+}
+
+// the body of a request to put a record, the lease is in seconds
+type recordRequest struct {
+	A     []string `json:"a"`
+	AAAA  []string `json:"aaaa"`
+	TTL   uint32   `json:"ttl"`
+	Lease uint32   `json:"lease"`
+}
+
+// the body of a request to renew a record, a new length for the lease in seconds
+type renewRequest struct {
+	Lease uint32 `json:"lease"`
+}
+
+// a record as it is returned by the api, the lease and when it ends are left out when there isn't one
+type recordResponse struct {
+	Name    string   `json:"name"`
+	A       []string `json:"a"`
+	AAAA    []string `json:"aaaa"`
+	TTL     uint32   `json:"ttl"`
+	Lease   uint32   `json:"lease,omitempty"`
+	Expires string   `json:"expires,omitempty"`
+}
+
+func newRecordResponse(record records.Record) recordResponse {
+	response := recordResponse{Name: record.Name, A: []string{}, AAAA: []string{}, TTL: record.TTL, Lease: uint32(record.Lease / time.Second)}
+	if !record.Expires.IsZero() {
+		response.Expires = record.Expires.UTC().Format(time.RFC3339)
+	}
+	for _, ip := range record.A {
+		response.A = append(response.A, ip.String())
+	}
+	for _, ip := range record.AAAA {
+		response.AAAA = append(response.AAAA, ip.String())
+	}
+	return response
+}
+
+// the records api: GET /v1/records lists every record and GET, PUT, and DELETE on /v1/records/<name>
+// get, create or replace, and remove the record with the name. POST /v1/records/<name>/renew starts the
+// lease of the record over. GET /v1/snapshot exports every record and POST /v1/snapshot imports them.
+// every request needs the bearer token.
+func recordsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(recordsPath, serveRecordList)
+	mux.HandleFunc(recordsPath+"/", serveRecord)
+	mux.HandleFunc(snapshotPath, serveSnapshot)
+	return authorized(mux)
+}
+
+// only lets requests with the records token through
+func authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if recordsToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(recordsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gyip"`)
+			http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writes the value as json with the status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func serveRecordList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	list := []recordResponse{}
+	for _, record := range recordStore.List() {
+		list = append(list, newRecordResponse(record))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func serveRecord(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, recordsPath+"/")
+	if strings.HasSuffix(path, renewSuffix) {
+		serveRenew(w, r, records.Name(strings.TrimSuffix(path, renewSuffix)))
+		return
+	}
+	name := records.Name(path)
+	if name == "" {
+		serveRecordList(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		record, found := recordStore.Get(name)
+		if !found {
+			http.Error(w, fmt.Sprintf("there is no record for %s", name), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, newRecordResponse(record))
+	case http.MethodPut:
+		request := recordRequest{}
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRecordBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("the record could not be read: %s", err), http.StatusBadRequest)
+			return
+		}
+		record, err := newRecord(name, request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		added, err := recordStore.Put(record)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("the record could not be kept: %s", err), http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusOK
+		if added {
+			status = http.StatusCreated
+		}
+		record, _ = recordStore.Get(record.Name)
+		logger.Info("Record registered", logging.F("name", record.Name), logging.F("a", request.A), logging.F("aaaa", request.AAAA), logging.F("ttl", record.TTL), logging.F("lease", record.Lease), logging.F("client", httpAddr(r.RemoteAddr)))
+		writeJSON(w, status, newRecordResponse(record))
+	case http.MethodDelete:
+		deleted, err := recordStore.Delete(name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("the record could not be deleted: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, fmt.Sprintf("there is no record for %s", name), http.StatusNotFound)
+			return
+		}
+		logger.Info("Record deleted", logging.F("name", name), logging.F("client", httpAddr(r.RemoteAddr)))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "only GET, PUT, and DELETE are supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// starts the lease of the record over, with the length from the body when it has one
+func serveRenew(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	request := renewRequest{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRecordBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("the lease could not be read: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	record, err := recordStore.Renew(name, time.Duration(request.Lease)*time.Second)
+	switch err {
+	case nil:
+	case records.ErrNotFound:
+		http.Error(w, fmt.Sprintf("there is no record for %s", name), http.StatusNotFound)
+		return
+	case records.ErrNoLease:
+		http.Error(w, fmt.Sprintf("the record for %s does not have a lease to renew", name), http.StatusConflict)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("the lease could not be renewed: %s", err), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Lease renewed", logging.F("name", record.Name), logging.F("lease", record.Lease), logging.F("expires", record.Expires.UTC().Format(time.RFC3339)), logging.F("client", httpAddr(r.RemoteAddr)))
+	writeJSON(w, http.StatusOK, newRecordResponse(record))
+}
+
+// exports every record as a snapshot or imports the records in one
+func serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		records.WriteSnapshot(w, recordStore.List())
+	case http.MethodPost:
+		list, err := records.ReadSnapshot(http.MaxBytesReader(w, r.Body, maxSnapshotBody))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("the snapshot could not be read: %s", err), http.StatusBadRequest)
+			return
+		}
+		imported, err := importRecords(list)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("the snapshot could not be imported: %s", err), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Records imported", logging.F("records", imported), logging.F("client", httpAddr(r.RemoteAddr)))
+		writeJSON(w, http.StatusOK, map[string]int{"imported": imported})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "only GET and POST are supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// removes the records whose lease has lapsed, each one is logged and counted
+func sweepLeases() {
+	for _, record := range recordStore.Sweep() {
+		leaseExpirations.Inc()
+		logger.Info("Lease lapsed", logging.F("name", record.Name), logging.F("expires", record.Expires.UTC().Format(time.RFC3339)))
+	}
+}
+
+// sweeps the records every interval, forever
+func watchLeases(interval time.Duration) {
+	for range time.Tick(interval) {
+		sweepLeases()
+	}
+}
+
+// the record for the name from the request, an error when the name is not in a served domain or the
+// addresses can't be given in answers for the domain
+func newRecord(name string, request recordRequest) (records.Record, error) {
+	record := records.Record{Name: name, TTL: request.TTL, Lease: time.Duration(request.Lease) * time.Second}
+	domain := domainOf(name)
+	if !checkDomain(name) || domain == "" || domain == name {
+		return record, fmt.Errorf("the name %s is not a valid name in one of the served domains", name)
+	}
+	domainConfig := findDomain(domain)
+
+	for _, address := range request.A {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() == nil {
+			return record, fmt.Errorf("the a address \"%s\" is not an ipv4 address", address)
+		}
+		record.A = append(record.A, ip.To4())
+	}
+	for _, address := range request.AAAA {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() != nil {
+			return record, fmt.Errorf("the aaaa address \"%s\" is not an ipv6 address", address)
+		}
+		record.AAAA = append(record.AAAA, ip)
+	}
+	if len(record.A)+len(record.AAAA) < 1 {
+		return record, fmt.Errorf("the record needs at least one a or aaaa address")
+	}
+	for _, ip := range append(append([]net.IP{}, record.A...), record.AAAA...) {
+		if !domainConfig.allowsAnswer(ip) {
+			return record, fmt.Errorf("the domain %s does not give %s in answers", domain, ip)
+		}
+	}
+
+	if record.TTL == 0 {
+		record.TTL = defaultRecordTTL
+	}
+	return record, nil
+}
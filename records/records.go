@@ -0,0 +1,239 @@
+package records
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound - there is no record with the name (or its lease has lapsed)
+var ErrNotFound = errors.New("there is no record with the name")
+
+// ErrNoLease - the record can't be renewed because it was not given a lease
+var ErrNoLease = errors.New("the record does not have a lease")
+
+// Record - a name that is answered with the given addresses instead of the addresses in the name
+type Record struct {
+	// the fully qualified name in lower case
+	Name string
+	// the ipv4 addresses that answer A questions and the ipv6 addresses that answer AAAA questions
+	A    []net.IP
+	AAAA []net.IP
+	TTL  uint32
+	// how long the record is kept each time it is put or renewed, zero keeps it until it is deleted
+	Lease time.Duration
+	// when the lease lapses, zero when there is no lease
+	Expires time.Time
+}
+
+// lapsed returns true if the record has a lease that is over
+func (record Record) lapsed(now time.Time) bool {
+	return !record.Expires.IsZero() && !now.Before(record.Expires)
+}
+
+// Store - the records that have been registered, safe to use from more than one goroutine. records
+// are kept as they are given and must not be changed once they have been put in the store. records
+// whose lease has lapsed are not found and are removed when the store is swept.
+type Store struct {
+	lock    sync.RWMutex
+	records map[string]Record
+	now     func() time.Time
+	// every change is written here before it is made, nil when records are only kept in memory
+	storage Storage
+}
+
+// NewStore - an empty store that only keeps records in memory
+func NewStore() *Store {
+	return &Store{records: map[string]Record{}, now: time.Now}
+}
+
+// Open - a store that starts with the records kept in the storage (except those whose lease has
+// lapsed) and writes every change to it
+func Open(storage Storage) (*Store, error) {
+	kept, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	store := NewStore()
+	store.storage = storage
+	now := store.now()
+	for _, record := range kept {
+		if !record.lapsed(now) {
+			store.records[Name(record.Name)] = record
+		}
+	}
+	return store, nil
+}
+
+// Close - closes the storage (if there is one)
+func (store *Store) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.storage == nil {
+		return nil
+	}
+	return store.storage.Close()
+}
+
+// Name - the form of the name that records are kept under: lower case and fully qualified
+func Name(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "" && !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	return name
+}
+
+// Get - the record with the name, false when there isn't one
+func (store *Store) Get(name string) (Record, bool) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	record, found := store.records[Name(name)]
+	if !found || record.lapsed(store.now()) {
+		return Record{}, false
+	}
+	return record, true
+}
+
+// Put - adds the record or replaces the record with the same name, true when it was added. a record
+// with a lease and without an expiry lapses once the lease is over. nothing changes when the record
+// can't be written to the storage.
+func (store *Store) Put(record Record) (bool, error) {
+	record.Name = Name(record.Name)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	now := store.now()
+	if record.Lease > 0 && record.Expires.IsZero() {
+		record.Expires = now.Add(record.Lease)
+	}
+	if err := store.write(record); err != nil {
+		return false, err
+	}
+	existing, found := store.records[record.Name]
+	store.records[record.Name] = record
+	return !found || existing.lapsed(now), nil
+}
+
+// PutAll - puts every record like Put under the same lock, so that they are all seen at once. nothing
+// changes when the records can't all be written to the storage.
+func (store *Store) PutAll(list []Record) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	now := store.now()
+	put := make([]Record, 0, len(list))
+	for _, record := range list {
+		record.Name = Name(record.Name)
+		if record.Lease > 0 && record.Expires.IsZero() {
+			record.Expires = now.Add(record.Lease)
+		}
+		put = append(put, record)
+	}
+	if store.storage != nil {
+		if err := store.storage.PutAll(put); err != nil {
+			return err
+		}
+	}
+	for _, record := range put {
+		store.records[record.Name] = record
+	}
+	return nil
+}
+
+// writes the record to the storage (if there is one)
+func (store *Store) write(record Record) error {
+	if store.storage == nil {
+		return nil
+	}
+	return store.storage.Put(record)
+}
+
+// Renew - starts the lease of the record over, with the given lease when it isn't zero
+func (store *Store) Renew(name string, lease time.Duration) (Record, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	name = Name(name)
+	now := store.now()
+	record, found := store.records[name]
+	if !found || record.lapsed(now) {
+		return Record{}, ErrNotFound
+	}
+	if lease > 0 {
+		record.Lease = lease
+	}
+	if record.Lease <= 0 {
+		return record, ErrNoLease
+	}
+	record.Expires = now.Add(record.Lease)
+	if err := store.write(record); err != nil {
+		return Record{}, err
+	}
+	store.records[name] = record
+	return record, nil
+}
+
+// Sweep - removes the records whose lease has lapsed and returns them ordered by name. they are left in
+// the storage, which doesn't give back lapsed records anyway.
+func (store *Store) Sweep() []Record {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	now := store.now()
+	lapsed := []Record{}
+	for name, record := range store.records {
+		if record.lapsed(now) {
+			lapsed = append(lapsed, record)
+			delete(store.records, name)
+		}
+	}
+	sortRecords(lapsed)
+	return lapsed
+}
+
+// Delete - removes the record with the name, false when there wasn't one. nothing changes when the
+// record can't be removed from the storage.
+func (store *Store) Delete(name string) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	name = Name(name)
+	record, found := store.records[name]
+	if !found {
+		return false, nil
+	}
+	if store.storage != nil {
+		if err := store.storage.Delete(name); err != nil {
+			return false, err
+		}
+	}
+	delete(store.records, name)
+	return !record.lapsed(store.now()), nil
+}
+
+// List - every record (that has not lapsed) ordered by name
+func (store *Store) List() []Record {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	now := store.now()
+	list := make([]Record, 0, len(store.records))
+	for _, record := range store.records {
+		if !record.lapsed(now) {
+			list = append(list, record)
+		}
+	}
+	sortRecords(list)
+	return list
+}
+
+// Len - the number of records, including those that have lapsed but have not been swept
+func (store *Store) Len() int {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return len(store.records)
+}
+
+func sortRecords(list []Record) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+}
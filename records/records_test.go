@@ -0,0 +1,121 @@
+package records
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestName(t *testing.T) {
+	data := []struct {
+		name     string
+		expected string
+	}{
+		{"MyApp.Dev.gyip.io", "myapp.dev.gyip.io."},
+		{"myapp.dev.gyip.io.", "myapp.dev.gyip.io."},
+		{" myapp.gyip.io ", "myapp.gyip.io."},
+		{"", ""},
+	}
+
+	for _, item := range data {
+		if name := Name(item.name); name != item.expected {
+			t.Errorf("The name '%s' was kept as '%s' instead of '%s'", item.name, name, item.expected)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore()
+
+	if added, _ := store.Put(Record{Name: "b.gyip.io", A: []net.IP{net.ParseIP("10.0.0.2")}, TTL: 60}); !added {
+		t.Errorf("A new record should have been added")
+	}
+	if added, _ := store.Put(Record{Name: "A.gyip.io.", AAAA: []net.IP{net.ParseIP("::1")}}); !added {
+		t.Errorf("A new record should have been added")
+	}
+	if added, _ := store.Put(Record{Name: "B.GYIP.IO.", A: []net.IP{net.ParseIP("10.0.0.3")}, TTL: 30}); added {
+		t.Errorf("A record with the same name should have replaced the first one")
+	}
+
+	record, found := store.Get("b.gyip.io.")
+	if !found || record.TTL != 30 || !record.A[0].Equal(net.ParseIP("10.0.0.3")) {
+		t.Errorf("The replaced record was not found (was: %v)", record)
+	}
+	if _, found := store.Get("c.gyip.io."); found {
+		t.Errorf("A record that was never put should not be found")
+	}
+
+	list := store.List()
+	if len(list) != 2 || list[0].Name != "a.gyip.io." || list[1].Name != "b.gyip.io." || store.Len() != 2 {
+		t.Errorf("The records were not listed in order (was: %v)", list)
+	}
+
+	if deleted, _ := store.Delete("A.gyip.io"); !deleted {
+		t.Errorf("The record should have been deleted")
+	}
+	if deleted, _ := store.Delete("a.gyip.io."); deleted {
+		t.Errorf("The record should only be deleted once")
+	}
+	if _, found := store.Get("a.gyip.io."); found || store.Len() != 1 {
+		t.Errorf("The deleted record should not be found")
+	}
+}
+
+func TestLeases(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewStore()
+	store.now = func() time.Time {
+		return now
+	}
+
+	store.Put(Record{Name: "short.gyip.io", A: []net.IP{net.ParseIP("10.0.0.1")}, Lease: time.Minute})
+	store.Put(Record{Name: "long.gyip.io", A: []net.IP{net.ParseIP("10.0.0.2")}, Lease: time.Hour})
+	store.Put(Record{Name: "forever.gyip.io", A: []net.IP{net.ParseIP("10.0.0.3")}})
+	if record, _ := store.Get("short.gyip.io"); !record.Expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("The lease should end a minute after the record was put (was: %s)", record.Expires)
+	}
+
+	if _, err := store.Renew("forever.gyip.io", 0); err != ErrNoLease {
+		t.Errorf("A record without a lease should not be renewed (was: %v)", err)
+	}
+	if _, err := store.Renew("missing.gyip.io", time.Minute); err != ErrNotFound {
+		t.Errorf("A record that doesn't exist should not be renewed (was: %v)", err)
+	}
+
+	// the short lease lapses and is no longer found even before the store is swept
+	now = now.Add(time.Minute)
+	if _, found := store.Get("short.gyip.io"); found {
+		t.Errorf("A record with a lapsed lease should not be found")
+	}
+	if _, err := store.Renew("short.gyip.io", 0); err != ErrNotFound {
+		t.Errorf("A record with a lapsed lease should not be renewed (was: %v)", err)
+	}
+	if list := store.List(); len(list) != 2 || store.Len() != 3 {
+		t.Errorf("The lapsed record should not be listed but should be kept until it is swept (was: %v)", list)
+	}
+
+	// renewing starts the lease over, with a new length when one is given
+	renewed, err := store.Renew("long.gyip.io", 2*time.Hour)
+	if err != nil || !renewed.Expires.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("The record was not renewed for two hours (was: %s, %v)", renewed.Expires, err)
+	}
+	now = now.Add(90 * time.Minute)
+	renewed, err = store.Renew("long.gyip.io", 0)
+	if err != nil || !renewed.Expires.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("The record was not renewed with its own lease (was: %s, %v)", renewed.Expires, err)
+	}
+
+	lapsed := store.Sweep()
+	if len(lapsed) != 1 || lapsed[0].Name != "short.gyip.io." || store.Len() != 2 {
+		t.Errorf("Only the lapsed record should have been swept (was: %v)", lapsed)
+	}
+	if lapsed := store.Sweep(); len(lapsed) != 0 {
+		t.Errorf("A record should only be swept once (was: %v)", lapsed)
+	}
+
+	// a name whose lease has lapsed can be put again as a new record
+	now = now.Add(3 * time.Hour)
+	if added, _ := store.Put(Record{Name: "long.gyip.io", A: []net.IP{net.ParseIP("10.0.0.2")}}); !added {
+		t.Errorf("A record that replaces a lapsed record should be new")
+	}
+}
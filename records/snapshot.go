@@ -0,0 +1,86 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Snapshot - records written as json, to keep a copy of them or to move them to another server
+type Snapshot struct {
+	Records []Record `json:"records"`
+}
+
+// the json form of a record, the lease is in seconds and the addresses are text
+type jsonRecord struct {
+	Name    string     `json:"name"`
+	A       []string   `json:"a"`
+	AAAA    []string   `json:"aaaa"`
+	TTL     uint32     `json:"ttl"`
+	Lease   uint32     `json:"lease,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// MarshalJSON - the record in the same form that the records api uses
+func (record Record) MarshalJSON() ([]byte, error) {
+	value := jsonRecord{Name: record.Name, A: []string{}, AAAA: []string{}, TTL: record.TTL, Lease: uint32(record.Lease / time.Second)}
+	for _, ip := range record.A {
+		value.A = append(value.A, ip.String())
+	}
+	for _, ip := range record.AAAA {
+		value.AAAA = append(value.AAAA, ip.String())
+	}
+	if !record.Expires.IsZero() {
+		expires := record.Expires.UTC()
+		value.Expires = &expires
+	}
+	return json.Marshal(value)
+}
+
+// UnmarshalJSON - reads the record from the form written by MarshalJSON
+func (record *Record) UnmarshalJSON(data []byte) error {
+	value := jsonRecord{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	read := Record{Name: value.Name, TTL: value.TTL, Lease: time.Duration(value.Lease) * time.Second}
+	for _, address := range value.A {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("the a address \"%s\" of %s is not an ipv4 address", address, value.Name)
+		}
+		read.A = append(read.A, ip.To4())
+	}
+	for _, address := range value.AAAA {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("the aaaa address \"%s\" of %s is not an ipv6 address", address, value.Name)
+		}
+		read.AAAA = append(read.AAAA, ip)
+	}
+	if value.Expires != nil {
+		read.Expires = *value.Expires
+	}
+	*record = read
+	return nil
+}
+
+// WriteSnapshot - writes the records as a snapshot
+func WriteSnapshot(w io.Writer, list []Record) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(Snapshot{Records: list})
+}
+
+// ReadSnapshot - the records in a snapshot
+func ReadSnapshot(r io.Reader) ([]Record, error) {
+	snapshot := Snapshot{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot.Records, nil
+}
@@ -0,0 +1,52 @@
+package records
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	expires := time.Date(2018, 1, 1, 0, 5, 0, 0, time.UTC)
+	list := []Record{
+		{Name: "a.gyip.io.", A: []net.IP{net.ParseIP("10.0.0.1").To4()}, AAAA: []net.IP{net.ParseIP("fd00::1")}, TTL: 30},
+		{Name: "b.gyip.io.", A: []net.IP{net.ParseIP("10.0.0.2").To4()}, TTL: 60, Lease: 5 * time.Minute, Expires: expires},
+	}
+	buffer := bytes.Buffer{}
+	if err := WriteSnapshot(&buffer, list); err != nil {
+		t.Fatalf("The snapshot could not be written: %s", err)
+	}
+	if !strings.Contains(buffer.String(), `"lease": 300`) || !strings.Contains(buffer.String(), `"expires": "2018-01-01T00:05:00Z"`) {
+		t.Errorf("The lease was not written in seconds with the time it ends (was: %s)", buffer.String())
+	}
+
+	read, err := ReadSnapshot(&buffer)
+	if err != nil || len(read) != 2 {
+		t.Fatalf("The snapshot could not be read back (was: %v, %v)", read, err)
+	}
+	if read[0].Name != "a.gyip.io." || read[0].TTL != 30 || !read[0].A[0].Equal(list[0].A[0]) || !read[0].AAAA[0].Equal(list[0].AAAA[0]) || !read[0].Expires.IsZero() {
+		t.Errorf("The first record was read back as %v", read[0])
+	}
+	if read[1].Lease != 5*time.Minute || !read[1].Expires.Equal(expires) || len(read[1].AAAA) != 0 {
+		t.Errorf("The leased record was read back as %v", read[1])
+	}
+
+	data := []struct {
+		snapshot string
+		contains string
+	}{
+		{`{"records":[{"name":"a.gyip.io.","a":["fd00::1"]}]}`, "not an ipv4 address"},
+		{`{"records":[{"name":"a.gyip.io.","aaaa":["10.0.0.1"]}]}`, "not an ipv6 address"},
+		{`{"records":[{"name":"a.gyip.io.","a":["nope"]}]}`, "not an ipv4 address"},
+		{`{"entries":[]}`, "unknown field"},
+		{`[]`, "cannot unmarshal"},
+	}
+
+	for _, item := range data {
+		if _, err := ReadSnapshot(strings.NewReader(item.snapshot)); err == nil || !strings.Contains(err.Error(), item.contains) {
+			t.Errorf("The snapshot %s should not have been read because of '%s' (was: %v)", item.snapshot, item.contains, err)
+		}
+	}
+}
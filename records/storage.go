@@ -0,0 +1,271 @@
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// the log is compacted once it has at least this many lines and more than twice as many lines as records
+const compactMinimum = 1024
+
+// ErrClosed - the storage can't be written to because it has been closed
+var ErrClosed = errors.New("the storage is closed")
+
+// Storage - where records are kept so that they outlast the process. the store writes every change to
+// its storage before it makes it, so a storage only has to keep what it is given.
+type Storage interface {
+	// Load - every record that has been kept, in any order
+	Load() ([]Record, error)
+	// Put - keeps the record, replacing any record with the same name
+	Put(record Record) error
+	// PutAll - keeps every record like Put, or none of them when they can't all be kept
+	PutAll(list []Record) error
+	// Delete - removes the record with the (canonical) name
+	Delete(name string) error
+	// Close - stops writing to the storage
+	Close() error
+}
+
+// FileStorage - keeps records in a file as a log with one json line for each record that is put or
+// deleted. the log is written again with only the records that are left (compacted) when it is opened
+// and once most of its lines are for records that have been replaced, deleted, or have lapsed.
+type FileStorage struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	records map[string]Record
+	// the lines in the log and its size, a change that can't be written is cut off at the size
+	lines int
+	size  int64
+	now   func() time.Time
+}
+
+// the change on each line of the log
+type logEntry struct {
+	Put    *Record `json:"put,omitempty"`
+	Delete string  `json:"delete,omitempty"`
+}
+
+// OpenFile - the storage kept in the file at the path, the file is created when it doesn't exist
+func OpenFile(path string) (*FileStorage, error) {
+	storage := &FileStorage{path: path, records: map[string]Record{}, now: time.Now}
+	if err := storage.replay(); err != nil {
+		return nil, err
+	}
+	if err := storage.compact(); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// reads the log into the records
+func (storage *FileStorage) replay() error {
+	file, err := os.Open(storage.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a last line without an end was cut off while it was being written, the change was never made
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) < 1 {
+			continue
+		}
+		entry := logEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("%s:%d: %s", storage.path, number, err)
+		}
+		if entry.Put != nil {
+			storage.records[Name(entry.Put.Name)] = *entry.Put
+		} else if entry.Delete != "" {
+			delete(storage.records, Name(entry.Delete))
+		}
+	}
+}
+
+// writes the records (without those that have lapsed) to a new log that replaces the old one
+func (storage *FileStorage) compact() error {
+	temporary := storage.path + ".tmp"
+	file, err := os.OpenFile(temporary, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	// the new log is only used once it has been completely written
+	abandon := func(err error) error {
+		file.Close()
+		os.Remove(temporary)
+		return err
+	}
+
+	now := storage.now()
+	list := make([]Record, 0, len(storage.records))
+	for name, record := range storage.records {
+		if record.lapsed(now) {
+			delete(storage.records, name)
+			continue
+		}
+		list = append(list, record)
+	}
+	sortRecords(list)
+	buffer := bytes.Buffer{}
+	for idx := range list {
+		line, err := json.Marshal(logEntry{Put: &list[idx]})
+		if err != nil {
+			return abandon(err)
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+	}
+	if _, err := file.Write(buffer.Bytes()); err != nil {
+		return abandon(err)
+	}
+	if err := file.Sync(); err != nil {
+		return abandon(err)
+	}
+	if err := os.Rename(temporary, storage.path); err != nil {
+		return abandon(err)
+	}
+	syncDirectory(filepath.Dir(storage.path))
+
+	// the new log is appended to from here on
+	if storage.file != nil {
+		storage.file.Close()
+	}
+	storage.file = file
+	storage.lines = len(list)
+	storage.size = int64(buffer.Len())
+	return nil
+}
+
+// makes a rename in the directory last through a crash, where the system allows it
+func syncDirectory(path string) {
+	directory, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	directory.Sync()
+	directory.Close()
+}
+
+// writes the changes to the end of the log in a single write
+func (storage *FileStorage) append(entries ...logEntry) error {
+	if storage.file == nil {
+		return ErrClosed
+	}
+	buffer := bytes.Buffer{}
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+	}
+	if _, err := storage.file.Write(buffer.Bytes()); err != nil {
+		// what was written of the lines is removed so that the next change starts on its own line
+		storage.file.Truncate(storage.size)
+		return err
+	}
+	if err := storage.file.Sync(); err != nil {
+		return err
+	}
+	storage.lines += len(entries)
+	storage.size += int64(buffer.Len())
+	return nil
+}
+
+// compacts the log once most of it is no longer needed. the change that was just written is already
+// kept so a log that can't be compacted is left to grow and is tried again after the next change.
+func (storage *FileStorage) maybeCompact() {
+	if storage.lines >= compactMinimum && storage.lines > 2*len(storage.records) {
+		storage.compact()
+	}
+}
+
+// Load - every record in the log
+func (storage *FileStorage) Load() ([]Record, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	list := make([]Record, 0, len(storage.records))
+	for _, record := range storage.records {
+		list = append(list, record)
+	}
+	return list, nil
+}
+
+// Put - writes the record to the log
+func (storage *FileStorage) Put(record Record) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	record.Name = Name(record.Name)
+	if err := storage.append(logEntry{Put: &record}); err != nil {
+		return err
+	}
+	storage.records[record.Name] = record
+	storage.maybeCompact()
+	return nil
+}
+
+// PutAll - writes the records to the log together, none of them are kept when the write fails
+func (storage *FileStorage) PutAll(list []Record) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	entries := make([]logEntry, 0, len(list))
+	for idx := range list {
+		record := list[idx]
+		record.Name = Name(record.Name)
+		entries = append(entries, logEntry{Put: &record})
+	}
+	if err := storage.append(entries...); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		storage.records[entry.Put.Name] = *entry.Put
+	}
+	storage.maybeCompact()
+	return nil
+}
+
+// Delete - writes the removal of the record to the log
+func (storage *FileStorage) Delete(name string) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	name = Name(name)
+	if err := storage.append(logEntry{Delete: name}); err != nil {
+		return err
+	}
+	delete(storage.records, name)
+	storage.maybeCompact()
+	return nil
+}
+
+// Close - closes the log, it can't be written to afterward
+func (storage *FileStorage) Close() error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	if storage.file == nil {
+		return nil
+	}
+	err := storage.file.Close()
+	storage.file = nil
+	return err
+}
@@ -0,0 +1,120 @@
+package records
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the lines of the log
+func readLog(t *testing.T, path string) []string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("The log could not be read: %s", err)
+	}
+	return strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "records")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "records.log")
+
+	storage, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("The storage could not be opened: %s", err)
+	}
+	store, err := Open(storage)
+	if err != nil || store.Len() != 0 {
+		t.Fatalf("A new storage should open as an empty store (was: %d, %v)", store.Len(), err)
+	}
+	store.Put(Record{Name: "a.gyip.io", A: []net.IP{net.ParseIP("10.0.0.1").To4()}, TTL: 30})
+	store.Put(Record{Name: "b.gyip.io", AAAA: []net.IP{net.ParseIP("fd00::2")}, Lease: time.Hour})
+	store.Put(Record{Name: "c.gyip.io", A: []net.IP{net.ParseIP("10.0.0.3").To4()}})
+	store.Put(Record{Name: "lapsed.gyip.io", A: []net.IP{net.ParseIP("10.0.0.4").To4()}, Lease: time.Minute, Expires: time.Now().Add(-time.Second)})
+	store.Delete("c.gyip.io")
+	renewed, _ := store.Renew("b.gyip.io", 2*time.Hour)
+	if lines := readLog(t, path); len(lines) != 6 {
+		t.Errorf("Each change should have been written as a line (was: %v)", lines)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("The store could not be closed: %s", err)
+	}
+	if _, err := store.Put(Record{Name: "d.gyip.io", A: []net.IP{net.ParseIP("10.0.0.5").To4()}}); err != ErrClosed {
+		t.Errorf("A closed storage should not be written to (was: %v)", err)
+	}
+	if _, found := store.Get("d.gyip.io"); found {
+		t.Errorf("A record that could not be written should not be kept")
+	}
+	if err := store.PutAll([]Record{{Name: "d.gyip.io", A: []net.IP{net.ParseIP("10.0.0.5").To4()}}, {Name: "e.gyip.io", A: []net.IP{net.ParseIP("10.0.0.6").To4()}}}); err != ErrClosed || store.Len() != 3 {
+		t.Errorf("Records that could not be written together should not be kept (was: %d, %v)", store.Len(), err)
+	}
+
+	// a change that was cut off while it was written is left out
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"delete":"a.gy`)
+	file.Close()
+
+	storage, err = OpenFile(path)
+	if err != nil {
+		t.Fatalf("The storage could not be opened again: %s", err)
+	}
+	defer storage.Close()
+	store, err = Open(storage)
+	if err != nil {
+		t.Fatalf("The store could not be opened again: %s", err)
+	}
+	list := store.List()
+	if len(list) != 2 || list[0].Name != "a.gyip.io." || list[0].TTL != 30 || !list[0].A[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("The records were not kept (was: %v)", list)
+	}
+	if len(list) == 2 && (list[1].Lease != 2*time.Hour || !list[1].Expires.Equal(renewed.Expires) || !list[1].AAAA[0].Equal(net.ParseIP("fd00::2"))) {
+		t.Errorf("The renewed lease was not kept (was: %v)", list[1])
+	}
+	// the log only has the records that are left once it is opened
+	if lines := readLog(t, path); len(lines) != 2 {
+		t.Errorf("The log should have been compacted when it was opened (was: %v)", lines)
+	}
+
+	// the log is compacted once most of its lines are not needed
+	storage.lines = compactMinimum
+	store.Put(Record{Name: "a.gyip.io", A: []net.IP{net.ParseIP("10.0.0.6").To4()}})
+	if lines := readLog(t, path); len(lines) != 2 || storage.lines != 2 || !strings.Contains(lines[0], "10.0.0.6") {
+		t.Errorf("The log should have been compacted after it grew (was: %v)", lines)
+	}
+
+	// records that are put together are written together
+	if err := store.PutAll([]Record{{Name: "d.gyip.io", A: []net.IP{net.ParseIP("10.0.0.5").To4()}}, {Name: "E.gyip.io", A: []net.IP{net.ParseIP("10.0.0.6").To4()}, Lease: time.Hour}}); err != nil {
+		t.Errorf("The records could not be put together: %s", err)
+	}
+	if lines := readLog(t, path); len(lines) != 4 || storage.lines != 4 || len(storage.records) != 4 {
+		t.Errorf("Each record that was put together should have been written as a line (was: %v)", lines)
+	}
+	if record, found := store.Get("e.gyip.io."); !found || record.Expires.IsZero() {
+		t.Errorf("The leased record that was put together should lapse (was: %v)", record)
+	}
+}
+
+func TestFileStorageErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "records")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "records.log")
+
+	ioutil.WriteFile(path, []byte("{\"put\":{\"name\":\"a.gyip.io.\",\"a\":[\"10.0.0.1\"]}}\nnot json\n"), 0600)
+	if _, err := OpenFile(path); err == nil || !strings.Contains(err.Error(), "records.log:2:") {
+		t.Errorf("A log with a broken line should not be opened (was: %v)", err)
+	}
+	if _, err := OpenFile(filepath.Join(dir, "missing", "records.log")); err == nil {
+		t.Errorf("A log in a directory that doesn't exist should not be opened")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrisruffalo/gyip/records"
+	"github.com/miekg/dns"
+)
+
+// replaces the registered records and the token for the test, the returned function puts them back
+func useRecords(token string) func() {
+	previousStore := recordStore
+	previousToken := recordsToken
+	recordStore = records.NewStore()
+	recordsToken = token
+	return func() {
+		recordStore = previousStore
+		recordsToken = previousToken
+	}
+}
+
+func TestRecordsAPI(t *testing.T) {
+	private, _ := buildAnswerList(AddressConfig{Preset: "private"}, AddressConfig{})
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}, {Name: "private.io.", answers: private}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	defer useRecords("secret")()
+	handler := adminHandler()
+
+	data := []struct {
+		method   string
+		path     string
+		token    string
+		body     string
+		status   int
+		contains string
+	}{
+		{"GET", "/v1/records", "", "", http.StatusUnauthorized, "bearer token"},
+		{"GET", "/v1/records", "wrong", "", http.StatusUnauthorized, "bearer token"},
+		{"GET", "/v1/records", "secret", "", http.StatusOK, "[]"},
+		{"PUT", "/v1/records/MyApp.dev.gyip.io", "secret", `{"a":["10.0.0.5"],"ttl":30}`, http.StatusCreated, `"name":"myapp.dev.gyip.io."`},
+		{"PUT", "/v1/records/myapp.dev.gyip.io.", "secret", `{"a":["10.0.0.6"],"aaaa":["fd00::6"]}`, http.StatusOK, `"ttl":60`},
+		{"GET", "/v1/records/myapp.dev.gyip.io", "secret", "", http.StatusOK, `"aaaa":["fd00::6"]`},
+		{"GET", "/v1/records/", "secret", "", http.StatusOK, `"a":["10.0.0.6"]`},
+		{"GET", "/v1/records/other.gyip.io", "secret", "", http.StatusNotFound, "no record"},
+		// records that can't be registered
+		{"PUT", "/v1/records/myapp.other.io", "secret", `{"a":["10.0.0.5"]}`, http.StatusBadRequest, "served domains"},
+		{"PUT", "/v1/records/gyip.io", "secret", `{"a":["10.0.0.5"]}`, http.StatusBadRequest, "served domains"},
+		{"PUT", "/v1/records/myapp.notgyip.io", "secret", `{"a":["10.0.0.5"]}`, http.StatusBadRequest, "served domains"},
+		{"PUT", "/v1/records/my_app.gyip.io", "secret", `{"a":["10.0.0.5"]}`, http.StatusBadRequest, "served domains"},
+		{"PUT", "/v1/records/myapp.gyip.io", "secret", `{"a":["fd00::5"]}`, http.StatusBadRequest, "not an ipv4 address"},
+		{"PUT", "/v1/records/myapp.gyip.io", "secret", `{"aaaa":["10.0.0.5"]}`, http.StatusBadRequest, "not an ipv6 address"},
+		{"PUT", "/v1/records/myapp.gyip.io", "secret", `{"ttl":60}`, http.StatusBadRequest, "at least one"},
+		{"PUT", "/v1/records/myapp.gyip.io", "secret", `{"a":["10.0.0.5"],"cname":"x"}`, http.StatusBadRequest, "unknown field"},
+		{"PUT", "/v1/records/myapp.private.io", "secret", `{"a":["8.8.8.8"]}`, http.StatusBadRequest, "does not give 8.8.8.8"},
+		{"POST", "/v1/records/myapp.gyip.io", "secret", "", http.StatusMethodNotAllowed, "supported"},
+		// leases and renewing them
+		{"PUT", "/v1/records/leased.gyip.io", "secret", `{"a":["10.0.0.9"],"lease":300}`, http.StatusCreated, `"lease":300,"expires":"`},
+		{"POST", "/v1/records/leased.gyip.io/renew", "secret", "", http.StatusOK, `"lease":300`},
+		{"POST", "/v1/records/leased.gyip.io/renew", "secret", `{"lease":600}`, http.StatusOK, `"lease":600`},
+		{"POST", "/v1/records/leased.gyip.io/renew", "secret", `{"lease":"long"}`, http.StatusBadRequest, "could not be read"},
+		{"GET", "/v1/records/leased.gyip.io/renew", "secret", "", http.StatusMethodNotAllowed, "only POST"},
+		{"POST", "/v1/records/myapp.dev.gyip.io/renew", "secret", "", http.StatusConflict, "does not have a lease"},
+		{"POST", "/v1/records/other.gyip.io/renew", "secret", "", http.StatusNotFound, "no record"},
+		{"DELETE", "/v1/records/myapp.dev.gyip.io", "secret", "", http.StatusNoContent, ""},
+		{"DELETE", "/v1/records/myapp.dev.gyip.io", "secret", "", http.StatusNotFound, "no record"},
+	}
+
+	for _, item := range data {
+		request := httptest.NewRequest(item.method, item.path, strings.NewReader(item.body))
+		if item.token != "" {
+			request.Header.Set("Authorization", "Bearer "+item.token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != item.status {
+			t.Errorf("%s %s was answered with %d instead of %d: %s", item.method, item.path, recorder.Code, item.status, recorder.Body.String())
+		}
+		if !strings.Contains(recorder.Body.String(), item.contains) {
+			t.Errorf("%s %s did not answer with '%s' (was: %s)", item.method, item.path, item.contains, recorder.Body.String())
+		}
+	}
+
+	// the api is not served without a token
+	recordsToken = ""
+	recorder := httptest.NewRecorder()
+	adminHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/records", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("The records api should not be served without a token (was: %d)", recorder.Code)
+	}
+}
+
+func TestRegisteredAnswers(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}, {Name: "short.io.", TTL: 10}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	defer useRecords("secret")()
+	recordStore.Put(records.Record{Name: "myapp.dev.gyip.io", A: []net.IP{net.ParseIP("10.0.0.5").To4()}, AAAA: []net.IP{net.ParseIP("fd00::5")}, TTL: 30})
+	recordStore.Put(records.Record{Name: "10.0.0.1.gyip.io", A: []net.IP{net.ParseIP("10.0.0.7").To4()}, TTL: 30})
+	recordStore.Put(records.Record{Name: "myapp.short.io", A: []net.IP{net.ParseIP("10.0.0.8").To4()}, TTL: 30})
+
+	data := []struct {
+		question string
+		qtype    uint16
+		expected []string
+		ttl      uint32
+	}{
+		{"myapp.dev.gyip.io.", dns.TypeA, []string{"10.0.0.5"}, 30},
+		{"MYAPP.dev.gyip.io.", dns.TypeAAAA, []string{"fd00::5"}, 30},
+		// the record is answered before the address in the name
+		{"10.0.0.1.gyip.io.", dns.TypeA, []string{"10.0.0.7"}, 30},
+		// registered ipv4 addresses don't answer AAAA questions
+		{"10.0.0.1.gyip.io.", dns.TypeAAAA, []string{}, 0},
+		// the domain can shorten the ttl
+		{"myapp.short.io.", dns.TypeA, []string{"10.0.0.8"}, 10},
+		{"other.dev.gyip.io.", dns.TypeA, []string{}, 0},
+	}
+
+	for _, item := range data {
+		answers, err := frameResponse(nil, item.qtype, item.question, domainOf(strings.ToLower(item.question)))
+		if err != nil || len(answers) != len(item.expected) {
+			t.Errorf("The question '%s' was answered with %v instead of %v (%v)", item.question, answers, item.expected, err)
+			continue
+		}
+		for idx, answer := range answers {
+			var ip net.IP
+			switch record := answer.(type) {
+			case *dns.A:
+				ip = record.A
+			case *dns.AAAA:
+				ip = record.AAAA
+			}
+			if ip.String() != item.expected[idx] || answer.Header().Ttl != item.ttl {
+				t.Errorf("The question '%s' was answered with %v instead of %s with ttl %d", item.question, answer, item.expected[idx], item.ttl)
+			}
+		}
+	}
+
+	if cmd := appliedCommand("myapp.dev.gyip.io."); cmd != "record" {
+		t.Errorf("A registered name should be counted as a record (was: %s)", cmd)
+	}
+	found := false
+	for _, line := range explain(nil, "myapp.dev.gyip.io.", "gyip.io.") {
+		found = found || strings.HasPrefix(line, "record:")
+	}
+	if !found {
+		t.Errorf("The explanation of a registered name should say that it was registered")
+	}
+}
+
+func TestLeasedAnswers(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	defer useRecords("secret")()
+	recordStore.Put(records.Record{Name: "leased.gyip.io", A: []net.IP{net.ParseIP("10.0.0.5").To4()}, TTL: 60, Lease: 10 * time.Second})
+
+	// the answer isn't cached past the end of the lease
+	answers, err := frameResponse(nil, dns.TypeA, "leased.gyip.io.", "gyip.io.")
+	if err != nil || len(answers) != 1 || answers[0].Header().Ttl > 10 {
+		t.Errorf("The leased record should be answered with a ttl that ends with the lease (was: %v, %v)", answers, err)
+	}
+	found := false
+	for _, line := range explain(nil, "leased.gyip.io.", "gyip.io.") {
+		found = found || strings.HasPrefix(line, "lease:")
+	}
+	if !found {
+		t.Errorf("The explanation of a leased name should say when the lease ends")
+	}
+}
+
+func TestSweepLeases(t *testing.T) {
+	defer useRecords("secret")()
+	recordStore.Put(records.Record{Name: "lapsed.gyip.io", A: []net.IP{net.ParseIP("10.0.0.5").To4()}, Lease: time.Minute, Expires: time.Now().Add(-time.Second)})
+	recordStore.Put(records.Record{Name: "leased.gyip.io", A: []net.IP{net.ParseIP("10.0.0.6").To4()}, Lease: time.Minute})
+	before := leaseExpirations.Value()
+
+	sweepLeases()
+	if recordStore.Len() != 1 || leaseExpirations.Value() != before+1 {
+		t.Errorf("Only the lapsed record should have been swept and counted (records: %d, counted: %v)", recordStore.Len(), leaseExpirations.Value()-before)
+	}
+	if _, found := recordStore.Get("leased.gyip.io"); !found {
+		t.Errorf("The record whose lease has not lapsed should have been kept")
+	}
+}
+
+func TestRecordResponse(t *testing.T) {
+	encoded, _ := json.Marshal(newRecordResponse(records.Record{Name: "myapp.gyip.io.", A: []net.IP{net.ParseIP("10.0.0.5").To4()}, TTL: 60}))
+	if string(encoded) != `{"name":"myapp.gyip.io.","a":["10.0.0.5"],"aaaa":[],"ttl":60}` {
+		t.Errorf("The record was encoded as %s", encoded)
+	}
+
+	encoded, _ = json.Marshal(newRecordResponse(records.Record{Name: "myapp.gyip.io.", A: []net.IP{net.ParseIP("10.0.0.5").To4()}, TTL: 60, Lease: time.Minute, Expires: time.Date(2018, 1, 1, 0, 1, 0, 0, time.UTC)}))
+	if string(encoded) != `{"name":"myapp.gyip.io.","a":["10.0.0.5"],"aaaa":[],"ttl":60,"lease":60,"expires":"2018-01-01T00:01:00Z"}` {
+		t.Errorf("The leased record was encoded as %s", encoded)
+	}
+}
+
+func TestRecordsSnapshot(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	defer useRecords("secret")()
+	handler := adminHandler()
+	recordStore.Put(records.Record{Name: "exported.gyip.io", A: []net.IP{net.ParseIP("10.0.0.5").To4()}, TTL: 30, Lease: time.Hour})
+
+	data := []struct {
+		method   string
+		body     string
+		status   int
+		contains string
+	}{
+		{"GET", "", http.StatusOK, `"name": "exported.gyip.io."`},
+		{"POST", `{"records":[{"name":"a.gyip.io","a":["10.0.0.6"]},{"name":"b.gyip.io.","aaaa":["fd00::7"],"lease":60,"expires":"2999-01-01T00:00:00Z"}]}`, http.StatusOK, `"imported":2`},
+		// lapsed records are left out
+		{"POST", `{"records":[{"name":"lapsed.gyip.io.","a":["10.0.0.8"],"lease":60,"expires":"2018-01-01T00:00:00Z"}]}`, http.StatusOK, `"imported":0`},
+		// nothing is imported when any record can't be
+		{"POST", `{"records":[{"name":"c.gyip.io.","a":["10.0.0.9"]},{"name":"other.io.","a":["10.0.0.9"]}]}`, http.StatusBadRequest, "served domains"},
+		{"POST", `{"records":[{"name":"c.gyip.io.","a":["fd00::9"]}]}`, http.StatusBadRequest, "not an ipv4 address"},
+		{"DELETE", "", http.StatusMethodNotAllowed, "supported"},
+	}
+
+	for _, item := range data {
+		request := httptest.NewRequest(item.method, "/v1/snapshot", strings.NewReader(item.body))
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != item.status || !strings.Contains(recorder.Body.String(), item.contains) {
+			t.Errorf("%s /v1/snapshot was answered with %d '%s' instead of %d '%s'", item.method, recorder.Code, recorder.Body.String(), item.status, item.contains)
+		}
+	}
+
+	list := recordStore.List()
+	if len(list) != 3 || list[0].Name != "a.gyip.io." || list[0].TTL != defaultRecordTTL || list[1].Expires.Year() != 2999 {
+		t.Errorf("The imported records were not kept as they were given (was: %v)", list)
+	}
+}
+
+func TestConfigureRecords(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+	defer useRecords("secret")()
+	dir, err := ioutil.TempDir("", "gyip")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "records.log")
+	snapshot := filepath.Join(dir, "snapshot.json")
+	ioutil.WriteFile(snapshot, []byte(`{"records":[{"name":"imported.gyip.io.","a":["10.0.0.5"],"lease":3600,"expires":"2999-01-01T00:00:00Z"}]}`), 0600)
+
+	// the imported record is written to the file and is still there when it is opened again
+	if err := configureRecords(RecordsConfig{File: file, Import: snapshot}); err != nil {
+		t.Fatalf("The records could not be configured: %s", err)
+	}
+	recordStore.Put(records.Record{Name: "registered.gyip.io", A: []net.IP{net.ParseIP("10.0.0.6").To4()}})
+	closeRecords()
+	if err := configureRecords(RecordsConfig{File: file}); err != nil {
+		t.Fatalf("The records could not be configured again: %s", err)
+	}
+	defer closeRecords()
+	list := recordStore.List()
+	if len(list) != 2 || list[0].Name != "imported.gyip.io." || list[0].Lease != time.Hour || list[1].Name != "registered.gyip.io." {
+		t.Errorf("The records were not kept in the file (was: %v)", list)
+	}
+
+	// records that the served domains no longer allow are dropped when the file is opened
+	closeRecords()
+	strict, _ := buildAnswerList(AddressConfig{}, AddressConfig{Deny: []string{"10.0.0.6"}})
+	servingDomains = []*DomainConfig{{Name: "gyip.io.", answers: strict}}
+	if err := configureRecords(RecordsConfig{File: file}); err != nil {
+		t.Fatalf("The records could not be configured with other domains: %s", err)
+	}
+	if list := recordStore.List(); len(list) != 1 || list[0].Name != "imported.gyip.io." {
+		t.Errorf("Only the records that are still allowed should have been loaded (was: %v)", list)
+	}
+	closeRecords()
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	if err := configureRecords(RecordsConfig{File: file}); err != nil || recordStore.Len() != 1 {
+		t.Errorf("The dropped records should have been removed from the file (was: %d, %v)", recordStore.Len(), err)
+	}
+
+	data := []struct {
+		config   RecordsConfig
+		contains string
+	}{
+		{RecordsConfig{File: filepath.Join(dir, "missing", "records.log")}, "records file could not be opened"},
+		{RecordsConfig{Import: filepath.Join(dir, "missing.json")}, "snapshot could not be opened"},
+		{RecordsConfig{Import: file}, "snapshot could not be read"},
+	}
+
+	for _, item := range data {
+		if err := configureRecords(item.config); err == nil || !strings.Contains(err.Error(), item.contains) {
+			t.Errorf("The records should not have been configured because the %s (was: %v)", item.contains, err)
+		}
+	}
+}
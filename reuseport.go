@@ -0,0 +1,79 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// binds a socket for the transport on the given address with SO_REUSEPORT so that more than one socket
+// can be bound to the address, the kernel spreads the packets and connections for the address across them
+func listenShared(transport string, addr string) (*socket, error) {
+	var ip net.IP
+	var port int
+	sockType := syscall.SOCK_STREAM
+	if transport == "udp" {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		ip, port, sockType = udpAddr.IP, udpAddr.Port, syscall.SOCK_DGRAM
+	} else {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		ip, port = tcpAddr.IP, tcpAddr.Port
+	}
+
+	// like the sockets bound by the net package an unspecified address gets a dual stack socket when it can
+	var fd int
+	var err error
+	var sockaddr syscall.Sockaddr
+	wildcard := ip == nil || ip.IsUnspecified()
+	if wildcard || ip.To4() == nil {
+		inet6 := &syscall.SockaddrInet6{Port: port}
+		copy(inet6.Addr[:], ip.To16())
+		sockaddr = inet6
+		fd, err = syscall.Socket(syscall.AF_INET6, sockType, 0)
+		if err == nil && wildcard {
+			syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0)
+		}
+	}
+	if (wildcard || ip.To4() != nil) && (sockaddr == nil || err != nil) {
+		inet4 := &syscall.SockaddrInet4{Port: port}
+		copy(inet4.Addr[:], ip.To4())
+		sockaddr = inet4
+		fd, err = syscall.Socket(syscall.AF_INET, sockType, 0)
+	}
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	syscall.CloseOnExec(fd)
+	file := os.NewFile(uintptr(fd), addr)
+	defer file.Close()
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, sockaddr); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if sockType == syscall.SOCK_STREAM {
+		if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+			return nil, os.NewSyscallError("listen", err)
+		}
+	}
+
+	sockets, err := socketsFromFiles([]*os.File{file}, []string{transport})
+	if err != nil {
+		return nil, err
+	}
+	return sockets[0], nil
+}
@@ -0,0 +1,4 @@
+package main
+
+// SO_REUSEPORT (the syscall package only defines it for the bsds)
+const soReusePort = 0xf
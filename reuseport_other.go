@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "fmt"
+
+// SO_REUSEPORT is not available so each address can only have one socket
+func listenShared(transport string, addr string) (*socket, error) {
+	return nil, fmt.Errorf("more than one socket for the %s address %s needs SO_REUSEPORT, which is only available on Linux and the BSDs", transport, addr)
+}
@@ -0,0 +1,116 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestListenShared(t *testing.T) {
+	for _, transport := range []string{"udp", "tcp"} {
+		first, err := listenShared(transport, "127.0.0.1:0")
+		if err != nil {
+			t.Errorf("Could not bind shared %s socket: %s", transport, err)
+			continue
+		}
+		addr := first.addr().String()
+
+		second, err := listenShared(transport, addr)
+		if err != nil {
+			t.Errorf("A second shared %s socket could not be bound to %s: %s", transport, addr, err)
+		} else if second.transport != transport || second.addr().String() != addr {
+			t.Errorf("The second shared %s socket was not as expected (transport: %s, address: %s)", transport, second.transport, second.addr())
+		}
+
+		// a socket that isn't shared can't use the address
+		if _, err := listen(transport, addr); err == nil {
+			t.Errorf("A %s socket that is not shared should not be bound to %s", transport, addr)
+		}
+
+		for _, s := range []*socket{first, second} {
+			if s == nil {
+				continue
+			}
+			if s.packetConn != nil {
+				s.packetConn.Close()
+			} else {
+				s.listener.Close()
+			}
+		}
+	}
+}
+
+// measures udp queries answered with the given number of shared sockets, ns/op is the time per query
+// so queries per second is 1e9 divided by it
+func benchmarkUDPSockets(b *testing.B, count int) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	previousLogger := queryLogger
+	queryLogger = nil
+	defer func() {
+		servingDomains = []*DomainConfig{}
+		queryLogger = previousLogger
+		resetRunning()
+	}()
+
+	// the first socket picks the port and the others share it
+	handler := edns(dns.HandlerFunc(handleQuestions))
+	started := make(chan error)
+	addr := "127.0.0.1:0"
+	for idx := 0; idx < count; idx++ {
+		s, err := listenShared("udp", addr)
+		if err != nil {
+			b.Fatalf("Could not bind shared socket: %s", err)
+		}
+		addr = s.addr().String()
+		go serveSocket(s, handler, started)
+		if err := <-started; err != nil {
+			b.Fatalf("Could not serve shared socket: %s", err)
+		}
+	}
+
+	question := new(dns.Msg)
+	question.SetQuestion("10.0.0.1.10.0.0.2.gyip.io.", dns.TypeA)
+	packed, _ := question.Pack()
+
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// each client has its own socket so that the kernel spreads them across the shared sockets
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			b.Errorf("Could not connect: %s", err)
+			return
+		}
+		defer conn.Close()
+		buffer := make([]byte, dns.DefaultMsgSize)
+		for pb.Next() {
+			if _, err := conn.Write(packed); err != nil {
+				b.Errorf("Could not send question: %s", err)
+				return
+			}
+			// a udp answer can be lost, which would otherwise wait forever
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Read(buffer); err != nil {
+				b.Errorf("Could not read answer: %s", err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+
+	shutdown(0)
+}
+
+func BenchmarkUDPSockets(b *testing.B) {
+	for _, count := range []int{1, 4} {
+		b.Run(fmt.Sprintf("sockets-%d", count), func(b *testing.B) {
+			benchmarkUDPSockets(b, count)
+		})
+	}
+}
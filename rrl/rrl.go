@@ -0,0 +1,190 @@
+package rrl
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Category - the kind of response that is being limited, each kind is counted separately
+type Category int
+
+const (
+	ANSWER Category = 1 + iota
+	NXDOMAIN
+	ERROR
+)
+
+// Action - what should be done with a response
+type Action int
+
+const (
+	SEND Action = 1 + iota
+	DROP
+	SLIP
+)
+
+// Config - options for response rate limiting
+type Config struct {
+	// the number of responses of each category a client prefix can get each second, 0 turns limiting off
+	ResponsesPerSecond int
+	// the number of seconds over which responses are counted, a client that goes over the limit
+	// stays limited until its rate has been back under the limit for this long
+	Window int
+	// every nth limited response is sent back truncated (so that real clients retry over tcp) instead of
+	// being dropped, 0 never slips and 1 always slips
+	Slip int
+	// the length of the prefix that groups ipv4 clients
+	IPv4PrefixLength int
+	// the length of the prefix that groups ipv6 clients
+	IPv6PrefixLength int
+}
+
+// Stats - counts of what the limiter has done
+type Stats struct {
+	Responses uint64
+	Dropped   uint64
+	Slipped   uint64
+}
+
+// the key for each bucket: the client prefix and response category
+type key struct {
+	prefix   string
+	category Category
+}
+
+// a balance of responses that is credited at the configured rate
+type bucket struct {
+	balance float64
+	updated time.Time
+	limited int
+}
+
+// Limiter - tracks the rate of responses to each client prefix
+type Limiter struct {
+	// updated with sync/atomic, these come first so that they are 64-bit aligned on 32-bit platforms
+	responses uint64
+	dropped   uint64
+	slipped   uint64
+
+	config    Config
+	ipv4Mask  net.IPMask
+	ipv6Mask  net.IPMask
+	mutex     sync.Mutex
+	buckets   map[key]*bucket
+	lastSweep time.Time
+}
+
+// New - creates a limiter, missing options are given default values
+func New(config Config) *Limiter {
+	if config.Window < 1 {
+		config.Window = 15
+	}
+	if config.Slip < 0 {
+		config.Slip = 0
+	}
+	if config.IPv4PrefixLength < 1 || config.IPv4PrefixLength > 32 {
+		config.IPv4PrefixLength = 24
+	}
+	if config.IPv6PrefixLength < 1 || config.IPv6PrefixLength > 128 {
+		config.IPv6PrefixLength = 56
+	}
+	return &Limiter{
+		config:   config,
+		ipv4Mask: net.CIDRMask(config.IPv4PrefixLength, 32),
+		ipv6Mask: net.CIDRMask(config.IPv6PrefixLength, 128),
+		buckets:  map[key]*bucket{},
+	}
+}
+
+// Enabled - returns true if the limiter limits anything
+func (limiter *Limiter) Enabled() bool {
+	return limiter != nil && limiter.config.ResponsesPerSecond > 0
+}
+
+// Check - records a response of the category to the client and returns what should be done with it
+func (limiter *Limiter) Check(ip net.IP, category Category, now time.Time) Action {
+	if !limiter.Enabled() || ip == nil {
+		return SEND
+	}
+	atomic.AddUint64(&limiter.responses, 1)
+
+	rate := float64(limiter.config.ResponsesPerSecond)
+	window := time.Duration(limiter.config.Window) * time.Second
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.sweep(now, window)
+
+	k := key{prefix: limiter.prefix(ip), category: category}
+	b, found := limiter.buckets[k]
+	if !found {
+		b = &bucket{balance: rate, updated: now}
+		limiter.buckets[k] = b
+	}
+
+	// credit the time since the last response, up to one second of responses
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.balance += elapsed * rate
+		if b.balance > rate {
+			b.balance = rate
+		}
+	}
+	b.updated = now
+
+	// debit this response, the balance can't go lower than one window of responses
+	b.balance--
+	if floor := -rate * float64(limiter.config.Window); b.balance < floor {
+		b.balance = floor
+	}
+
+	if b.balance >= 0 {
+		b.limited = 0
+		return SEND
+	}
+
+	b.limited++
+	if limiter.config.Slip > 0 && b.limited%limiter.config.Slip == 0 {
+		atomic.AddUint64(&limiter.slipped, 1)
+		return SLIP
+	}
+	atomic.AddUint64(&limiter.dropped, 1)
+	return DROP
+}
+
+// Stats - the counts of responses that were checked, dropped, and slipped
+func (limiter *Limiter) Stats() Stats {
+	if limiter == nil {
+		return Stats{}
+	}
+	return Stats{
+		Responses: atomic.LoadUint64(&limiter.responses),
+		Dropped:   atomic.LoadUint64(&limiter.dropped),
+		Slipped:   atomic.LoadUint64(&limiter.slipped),
+	}
+}
+
+// the client prefix that the address belongs to
+func (limiter *Limiter) prefix(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(limiter.ipv4Mask).String()
+	}
+	return ip.Mask(limiter.ipv6Mask).String()
+}
+
+// removes buckets that have not been used for two windows, even a bucket at the lowest balance
+// would be full again by then
+func (limiter *Limiter) sweep(now time.Time, window time.Duration) {
+	if now.Sub(limiter.lastSweep) < window {
+		return
+	}
+	limiter.lastSweep = now
+	for k, b := range limiter.buckets {
+		if now.Sub(b.updated) > 2*window {
+			delete(limiter.buckets, k)
+		}
+	}
+}
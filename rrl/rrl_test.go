@@ -0,0 +1,92 @@
+package rrl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDisabled(t *testing.T) {
+	limiter := New(Config{})
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		if action := limiter.Check(net.ParseIP("10.0.0.1"), ANSWER, now); action != SEND {
+			t.Fatalf("A disabled limiter should always send (was: %v)", action)
+		}
+	}
+
+	var nilLimiter *Limiter
+	if nilLimiter.Enabled() || nilLimiter.Check(net.ParseIP("10.0.0.1"), ANSWER, now) != SEND {
+		t.Errorf("A nil limiter should always send")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	limiter := New(Config{ResponsesPerSecond: 5, Window: 2, Slip: 2})
+	now := time.Now()
+	ip := net.ParseIP("10.0.0.1")
+
+	sent, dropped, slipped := 0, 0, 0
+	for i := 0; i < 20; i++ {
+		switch limiter.Check(ip, ANSWER, now) {
+		case SEND:
+			sent++
+		case DROP:
+			dropped++
+		case SLIP:
+			slipped++
+		}
+	}
+	if sent != 5 || dropped != 8 || slipped != 7 {
+		t.Errorf("The limiter did not act as expected (sent: %d, dropped: %d, slipped: %d)", sent, dropped, slipped)
+	}
+
+	// the same prefix is limited but a different category or prefix is not
+	if action := limiter.Check(net.ParseIP("10.0.0.200"), ANSWER, now); action == SEND {
+		t.Errorf("An address in the same prefix should be limited")
+	}
+	if action := limiter.Check(ip, NXDOMAIN, now); action != SEND {
+		t.Errorf("A different category should not be limited (was: %v)", action)
+	}
+	if action := limiter.Check(net.ParseIP("10.0.1.1"), ANSWER, now); action != SEND {
+		t.Errorf("A different prefix should not be limited (was: %v)", action)
+	}
+
+	// one second is not enough to pay back a full window
+	if action := limiter.Check(ip, ANSWER, now.Add(time.Second)); action == SEND {
+		t.Errorf("The prefix should still be limited after one second")
+	}
+	// but the balance can't stay below one full window
+	if action := limiter.Check(ip, ANSWER, now.Add(4*time.Second)); action != SEND {
+		t.Errorf("The prefix should not be limited after the window (was: %v)", action)
+	}
+
+	stats := limiter.Stats()
+	if stats.Responses != 25 || stats.Dropped+stats.Slipped != 17 {
+		t.Errorf("The limiter did not count as expected (was: %+v)", stats)
+	}
+}
+
+func TestIPv6Prefix(t *testing.T) {
+	limiter := New(Config{ResponsesPerSecond: 1})
+	now := time.Now()
+	limiter.Check(net.ParseIP("2001:db8:0:1::1"), ANSWER, now)
+	if action := limiter.Check(net.ParseIP("2001:db8:0:2::1"), ANSWER, now); action == SEND {
+		t.Errorf("Addresses in the same /56 should share a limit")
+	}
+	if action := limiter.Check(net.ParseIP("2001:db8:0:100::1"), ANSWER, now); action != SEND {
+		t.Errorf("Addresses in a different /56 should not share a limit (was: %v)", action)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	limiter := New(Config{ResponsesPerSecond: 1, Window: 1})
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.Check(net.IPv4(10, byte(i), 0, 1), ANSWER, now)
+	}
+	limiter.Check(net.ParseIP("10.200.0.1"), ANSWER, now.Add(3*time.Second))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("Unused buckets were not removed (remaining: %d)", len(limiter.buckets))
+	}
+}
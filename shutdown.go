@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the grace period used when none is configured
+const defaultGracePeriod = 10
+
+// how long a tcp connection is kept open for more questions while the server is running
+const tcpIdleTimeout = 8 * time.Second
+
+// ShutdownConfig - options for stopping the server
+type ShutdownConfig struct {
+	// the number of seconds that outstanding responses are given to finish when the server is stopped
+	GracePeriod int `json:"gracePeriod" yaml:"gracePeriod" toml:"gracePeriod"`
+}
+
+// the servers that have been started so that they can be stopped
+var (
+	runningLock        sync.Mutex
+	runningServers     []*dns.Server
+	runningHTTPServers []*http.Server
+)
+
+// set once the server starts stopping
+var stopping int32
+
+// returns true once the server has started stopping
+func isStopping() bool {
+	return atomic.LoadInt32(&stopping) != 0
+}
+
+// held (for reading) by every handler while it answers a question, shutdown takes it to wait for them to finish
+var answering sync.RWMutex
+
+// keeps track of a started dns server
+func addRunningServer(server *dns.Server) {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	runningServers = append(runningServers, server)
+}
+
+// keeps track of a started http server
+func addRunningHTTPServer(server *http.Server) {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	runningHTTPServers = append(runningHTTPServers, server)
+}
+
+// tcp connections are closed after the response that they are waiting for once the server is stopping
+func idleTimeout() time.Duration {
+	if isStopping() {
+		return 0
+	}
+	return tcpIdleTimeout
+}
+
+// wraps the handler so that shutdown can wait for the questions that are being answered
+func drained(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		answering.RLock()
+		defer answering.RUnlock()
+		next.ServeDNS(w, r)
+	})
+}
+
+// stops the running servers: new tcp connections and http requests are no longer accepted, the questions that
+// are being answered are given until the grace period is over to finish, and then the udp sockets are closed.
+// returns an error if everything did not finish before the grace period was over.
+func shutdown(grace time.Duration) error {
+	atomic.StoreInt32(&stopping, 1)
+	deadline := time.After(grace)
+
+	runningLock.Lock()
+	servers := append([]*dns.Server{}, runningServers...)
+	httpServers := append([]*http.Server{}, runningHTTPServers...)
+	runningLock.Unlock()
+
+	// stop accepting connections, the dns servers wait for their own connections but the wait is
+	// not limited by the grace period so only the wait for the handlers below counts
+	for _, server := range servers {
+		if server.Net != "udp" {
+			go server.Shutdown()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	httpDone := make(chan bool)
+	go func() {
+		var wg sync.WaitGroup
+		for _, server := range httpServers {
+			wg.Add(1)
+			go func(server *http.Server) {
+				defer wg.Done()
+				server.Shutdown(ctx)
+			}(server)
+		}
+		wg.Wait()
+		httpDone <- true
+	}()
+
+	// wait for the handlers that are answering questions, new questions wait until the udp sockets are closed
+	handlersDone := make(chan bool)
+	go func() {
+		answering.Lock()
+		handlersDone <- true
+	}()
+
+	select {
+	case <-handlersDone:
+	case <-deadline:
+		// don't keep the handlers waiting if they do finish
+		go func() {
+			<-handlersDone
+			answering.Unlock()
+		}()
+		return fmt.Errorf("questions were still being answered after %s", grace)
+	}
+	// questions that arrived while waiting are still answered if they can be
+	for _, server := range servers {
+		if server.Net == "udp" {
+			go server.Shutdown()
+		}
+	}
+	answering.Unlock()
+
+	select {
+	case <-httpDone:
+	case <-deadline:
+		return fmt.Errorf("https requests were still being answered after %s", grace)
+	}
+	return nil
+}
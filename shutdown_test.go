@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// starts a server on a random local port that answers every question after the given delay
+func startSlowServer(t *testing.T, transport string, delay time.Duration) *dns.Server {
+	started := make(chan bool)
+	handler := drained(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(delay)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	}))
+	server := &dns.Server{Addr: "127.0.0.1:0", Net: transport, Handler: handler, IdleTimeout: idleTimeout}
+	server.NotifyStartedFunc = func() {
+		started <- true
+	}
+	addRunningServer(server)
+	go server.ListenAndServe()
+	<-started
+	return server
+}
+
+// resets the servers, the sockets, the readiness of the listeners, and the stopping flag
+func resetRunning() {
+	runningServers = nil
+	runningHTTPServers = nil
+	runningSockets = nil
+	atomic.StoreInt32(&listenersStarted, 0)
+	atomic.StoreInt32(&listenerFailures, 0)
+	atomic.StoreInt32(&stopping, 0)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	defer resetRunning()
+	udp := startSlowServer(t, "udp", 300*time.Millisecond)
+	tcp := startSlowServer(t, "tcp", 300*time.Millisecond)
+	addresses := map[string]string{"udp": udp.PacketConn.LocalAddr().String(), "tcp": tcp.Listener.Addr().String()}
+
+	errs := make(chan error, len(addresses))
+	for transport, address := range addresses {
+		go func(transport string, address string) {
+			question := new(dns.Msg)
+			question.SetQuestion("gyip.io.", dns.TypeA)
+			_, _, err := (&dns.Client{Net: transport}).Exchange(question, address)
+			errs <- err
+		}(transport, address)
+	}
+
+	// the questions are being answered when the server is stopped
+	time.Sleep(100 * time.Millisecond)
+	if err := shutdown(2 * time.Second); err != nil {
+		t.Errorf("The server did not stop cleanly: %s", err)
+	}
+	for range addresses {
+		if err := <-errs; err != nil {
+			t.Errorf("A question asked before stopping was not answered: %s", err)
+		}
+	}
+
+	// and no new connections are accepted
+	question := new(dns.Msg)
+	question.SetQuestion("gyip.io.", dns.TypeA)
+	if _, _, err := (&dns.Client{Net: "tcp", Timeout: 500 * time.Millisecond}).Exchange(question, addresses["tcp"]); err == nil {
+		t.Errorf("A question was answered after the server stopped")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	defer resetRunning()
+	udp := startSlowServer(t, "udp", time.Second)
+
+	go func() {
+		question := new(dns.Msg)
+		question.SetQuestion("gyip.io.", dns.TypeA)
+		(&dns.Client{}).Exchange(question, udp.PacketConn.LocalAddr().String())
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := shutdown(200 * time.Millisecond); err == nil {
+		t.Errorf("Stopping should have timed out while a question was being answered")
+	}
+	// let the handler finish before the next test
+	time.Sleep(time.Second)
+}
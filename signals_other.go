@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+// there is no signal to hand the sockets over with on this platform
+var handoffSignals = []os.Signal{}
+
+// sockets are never handed over on this platform so there is no process to stop
+func stopProcess(pid int) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// the signals that hand the sockets over to a new process (to upgrade without downtime)
+var handoffSignals = []os.Signal{syscall.SIGUSR2}
+
+// tells the process with the pid to stop
+func stopProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
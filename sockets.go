@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chrisruffalo/gyip/logging"
+	"github.com/miekg/dns"
+)
+
+// a bound socket and the transport that is served on it. udp sockets have a packet connection and
+// every other transport has a tcp listener.
+type socket struct {
+	transport  string
+	packetConn *net.UDPConn
+	listener   *net.TCPListener
+	// stops serving the socket, set once it is being served
+	stop func()
+}
+
+// the sockets that are being served so that they can be handed to another process
+var (
+	socketLock     sync.Mutex
+	runningSockets []*socket
+)
+
+// keeps track of a socket that is being served
+func addRunningSocket(s *socket) {
+	socketLock.Lock()
+	defer socketLock.Unlock()
+	runningSockets = append(runningSockets, s)
+}
+
+// no longer keeps track of a socket that isn't being served
+func removeRunningSocket(s *socket) {
+	socketLock.Lock()
+	defer socketLock.Unlock()
+	for idx, running := range runningSockets {
+		if running == s {
+			runningSockets = append(runningSockets[:idx], runningSockets[idx+1:]...)
+			return
+		}
+	}
+}
+
+// the local address of the socket
+func (s *socket) addr() net.Addr {
+	if s.packetConn != nil {
+		return s.packetConn.LocalAddr()
+	}
+	return s.listener.Addr()
+}
+
+// a copy of the socket's file descriptor that can be given to another process
+func (s *socket) file() (*os.File, error) {
+	if s.packetConn != nil {
+		return s.packetConn.File()
+	}
+	return s.listener.File()
+}
+
+// binds a socket for the transport on the given address
+func listen(transport string, addr string) (*socket, error) {
+	if transport == "udp" {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		packetConn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		return &socket{transport: transport, packetConn: packetConn}, nil
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &socket{transport: transport, listener: listener}, nil
+}
+
+// turns files (from systemd or a parent process) into sockets. the transport is the name given for the
+// file when it is one that fits the kind of socket, otherwise udp sockets serve udp and tcp sockets serve tcp.
+func socketsFromFiles(files []*os.File, names []string) ([]*socket, error) {
+	sockets := []*socket{}
+	for idx, file := range files {
+		name := ""
+		if idx < len(names) {
+			name = names[idx]
+		}
+
+		// both make their own copy of the file descriptor
+		if packetConn, err := net.FilePacketConn(file); err == nil {
+			udpConn, ok := packetConn.(*net.UDPConn)
+			if !ok {
+				packetConn.Close()
+				return nil, fmt.Errorf("the socket \"%s\" is not a udp socket", file.Name())
+			}
+			sockets = append(sockets, &socket{transport: "udp", packetConn: udpConn})
+		} else if listener, err := net.FileListener(file); err == nil {
+			tcpListener, ok := listener.(*net.TCPListener)
+			if !ok {
+				listener.Close()
+				return nil, fmt.Errorf("the socket \"%s\" is not a tcp socket", file.Name())
+			}
+			transport := "tcp"
+			if name == "dot" || name == "doh" {
+				transport = name
+			}
+			sockets = append(sockets, &socket{transport: transport, listener: tcpListener})
+		} else {
+			return nil, fmt.Errorf("the file \"%s\" is not a udp or tcp socket", file.Name())
+		}
+		file.Close()
+	}
+	return sockets, nil
+}
+
+// serves the socket's transport and reports to started once it is being served (nil) or could not be (the error)
+func serveSocket(s *socket, handler dns.Handler, started chan<- error) {
+	addr := s.addr().String()
+	// every question is counted, logged, and tapped with the transport it was asked over
+	handler = observe(tap(handler, s.transport), s.transport)
+
+	listening := false
+	// set by stop, which is called from another goroutine
+	var stopped int32
+	notify := func() {
+		listening = true
+		started <- nil
+	}
+
+	addRunningSocket(s)
+	var err error
+	if s.transport == "doh" {
+		err = serveDoH(s, handler, notify)
+	} else {
+		server := &dns.Server{Net: s.transport, TsigSecret: nil, Handler: handler, UDPSize: dns.DefaultMsgSize, IdleTimeout: idleTimeout, NotifyStartedFunc: notify, DecorateWriter: countParseFailures(s.transport)}
+		s.stop = func() {
+			atomic.StoreInt32(&stopped, 1)
+			removeRunningSocket(s)
+			server.Shutdown()
+		}
+		switch s.transport {
+		case "udp":
+			server.PacketConn = s.packetConn
+		case "dot":
+			server.Net = "tcp-tls"
+			server.Listener = tls.NewListener(s.listener, serverTLS)
+		default:
+			server.Listener = s.listener
+		}
+		addRunningServer(server)
+		err = server.ActivateAndServe()
+	}
+	if !listening {
+		if err == nil {
+			err = fmt.Errorf("the server stopped before it started listening")
+		}
+		listenerErrors.Inc(s.transport)
+		started <- fmt.Errorf("the %s server on %s could not be started: %s", s.transport, addr, err)
+		return
+	}
+	// closing the listener while stopping is not a failure
+	if err != nil && !isStopping() && atomic.LoadInt32(&stopped) == 0 {
+		listenerErrors.Inc(s.transport)
+		markFailed()
+		logger.Error("Server failed", logging.F("transport", s.transport), logging.F("address", addr), logging.F("error", err))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// the certificate used by the encrypted listeners, nil when no listener is encrypted
+var serverTLS *tls.Config
+
+// TLSConfig - the certificate used by encrypted listeners
+type TLSConfig struct {
+	// the pem encoded certificate (chain) and private key
+	Cert string `json:"cert" yaml:"cert" toml:"cert"`
+	Key  string `json:"key" yaml:"key" toml:"key"`
+	// generates a self-signed certificate when no certificate is given, this is only useful for testing
+	SelfSigned bool `json:"selfSigned" yaml:"selfSigned" toml:"selfSigned"`
+}
+
+// the transports that need a certificate
+var encryptedTransports = []string{"dot", "doh"}
+
+// returns true if any listener uses a transport that needs a certificate
+func needsTLS(listeners []ListenerConfig) bool {
+	for _, listenerConfig := range listeners {
+		for _, transport := range listenerConfig.Transports {
+			if containsString(encryptedTransports, strings.ToLower(transport)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loads the configured certificate or generates a self-signed certificate for the domains and hosts
+func loadTLS(tlsConfig TLSConfig, domains []DomainConfig, listeners []ListenerConfig) (*tls.Config, error) {
+	var certificate tls.Certificate
+	var err error
+
+	if tlsConfig.Cert != "" || tlsConfig.Key != "" {
+		if tlsConfig.Cert == "" || tlsConfig.Key == "" {
+			return nil, fmt.Errorf("both a certificate and a key are required")
+		}
+		certificate, err = tls.LoadX509KeyPair(tlsConfig.Cert, tlsConfig.Key)
+		if err != nil {
+			return nil, fmt.Errorf("the certificate could not be loaded: %s", err)
+		}
+	} else if tlsConfig.SelfSigned {
+		names := []string{}
+		for _, domainConfig := range domains {
+			name := strings.TrimSuffix(domainConfig.Name, ".")
+			names = append(names, name, "*."+name)
+		}
+		for _, listenerConfig := range listeners {
+			hosts, _ := resolveHosts(listenerConfig.Host)
+			for _, host := range hosts {
+				// link local addresses (with a zone) aren't useful in a certificate
+				if !strings.Contains(host, "%") {
+					names = append(names, host)
+				}
+			}
+		}
+		certificate, err = selfSignedCertificate(names, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("a self-signed certificate could not be created: %s", err)
+		}
+	} else {
+		return nil, fmt.Errorf("a certificate and key are required (or use a self-signed certificate for testing)")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// creates a certificate, valid for a year, for the given dns names and ip addresses. addresses that
+// listen on every interface are replaced by the loopback addresses.
+func selfSignedCertificate(names []string, now time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gyip"}, CommonName: "gyip self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			if ip.IsUnspecified() {
+				template.IPAddresses = append(template.IPAddresses, net.ParseIP("127.0.0.1"), net.ParseIP("::1"))
+			} else {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else if name != "" {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	certificate, err := selfSignedCertificate([]string{"gyip.io", "*.gyip.io", "0.0.0.0", "10.0.0.1"}, time.Now())
+	if err != nil {
+		t.Fatalf("Could not create certificate: %s", err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %s", err)
+	}
+
+	for _, name := range []string{"gyip.io", "dns.gyip.io", "127.0.0.1", "::1", "10.0.0.1"} {
+		if err := parsed.VerifyHostname(name); err != nil {
+			t.Errorf("The certificate is not valid for '%s': %s", name, err)
+		}
+	}
+	if err := parsed.VerifyHostname("gyip.com"); err == nil {
+		t.Errorf("The certificate should not be valid for 'gyip.com'")
+	}
+}
+
+func TestLoadTLS(t *testing.T) {
+	domains := []DomainConfig{{Name: "gyip.io."}}
+	listeners := []ListenerConfig{{Host: "127.0.0.1", Port: 853, Transports: []string{"dot"}}}
+
+	if _, err := loadTLS(TLSConfig{}, domains, listeners); err == nil {
+		t.Errorf("A certificate should be required")
+	}
+	if _, err := loadTLS(TLSConfig{Cert: "cert.pem"}, domains, listeners); err == nil {
+		t.Errorf("A key should be required with a certificate")
+	}
+	if _, err := loadTLS(TLSConfig{Cert: "missing.pem", Key: "missing.key"}, domains, listeners); err == nil {
+		t.Errorf("A missing certificate should not load")
+	}
+	if loaded, err := loadTLS(TLSConfig{SelfSigned: true}, domains, listeners); err != nil || len(loaded.Certificates) != 1 {
+		t.Errorf("A self-signed certificate should be created (error: %v)", err)
+	}
+
+	if !needsTLS(listeners) || needsTLS([]ListenerConfig{{Transports: []string{"udp", "tcp"}}}) {
+		t.Errorf("Encrypted listeners were not found as expected")
+	}
+}
+
+func TestDoT(t *testing.T) {
+	servingDomains = []*DomainConfig{{Name: "gyip.io."}}
+	defer func() {
+		servingDomains = []*DomainConfig{}
+	}()
+
+	tlsConfig, err := loadTLS(TLSConfig{SelfSigned: true}, []DomainConfig{{Name: "gyip.io."}}, []ListenerConfig{{Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Could not create certificate: %s", err)
+	}
+
+	started := make(chan bool)
+	server := &dns.Server{Addr: "127.0.0.1:0", Net: "tcp-tls", TLSConfig: tlsConfig, Handler: edns(dns.HandlerFunc(handleQuestions))}
+	server.NotifyStartedFunc = func() {
+		started <- true
+	}
+	go server.ListenAndServe()
+	defer server.Shutdown()
+	<-started
+
+	client := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	question := new(dns.Msg)
+	question.SetQuestion("10.0.0.1.gyip.io.", dns.TypeA)
+	response, _, err := client.Exchange(question, server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not ask question over tls: %s", err)
+	}
+	if len(response.Answer) != 1 || !response.Answer[0].(*dns.A).A.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("The answer over tls was not as expected (was: %v)", response.Answer)
+	}
+}